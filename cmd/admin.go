@@ -3,10 +3,12 @@ package cmd
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
@@ -28,27 +30,26 @@ func (app *Application) adminDeleteUser(c *gin.Context) {
 		return
 	}
 
-	sessionToken, exists := c.Get("sessionToken")
-	if !exists {
-		c.AbortWithStatus(http.StatusUnauthorized)
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
 	// You can't delete yourself
-	if account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID)); err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		return
-	} else if account.ID == input.ID {
+	if actor.ID == input.ID {
 		c.AbortWithError(http.StatusBadRequest, ErrCantDeleteSelf)
 		return
 	}
 
-	if err = app.deleteAccount(input.ID); err != nil {
-		log.Err(err).Msg("Failed to delete account")
+	if err = app.deleteAccount(c, input.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to delete account")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	app.audit(c, actor.ID, AuditDeleteUser, accountTarget(input.ID), "")
+
 	c.String(http.StatusOK, fmt.Sprintf("User %d deleted", input.ID))
 }
 
@@ -67,11 +68,19 @@ func (app *Application) adminDeleteFiles(c *gin.Context) {
 		return
 	}
 
-	if err = app.deleteFilesFromAccount(input.ID); err != nil {
+	actor, err := app.accountFromContext(c)
+	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
+	if err = app.deleteFilesFromAccount(c, input.ID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditDeleteUserFiles, accountTarget(input.ID), "")
+
 	c.String(http.StatusOK, "Files deleted")
 }
 
@@ -90,11 +99,19 @@ func (app *Application) adminDeleteSessions(c *gin.Context) {
 		return
 	}
 
-	if err = app.db.deleteSessionsFromAccount(input.ID); err != nil {
+	actor, err := app.accountFromContext(c)
+	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
+	if err = app.db.withContext(c).deleteSessionsFromAccount(input.ID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditDeleteSessions, accountTarget(input.ID), "")
+
 	c.String(http.StatusOK, "Sessions deleted")
 }
 
@@ -113,20 +130,28 @@ func (app *Application) adminDeleteUploadTokens(c *gin.Context) {
 		return
 	}
 
-	if err = app.db.deleteUploadTokensFromAccount(input.ID); err != nil {
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = app.db.withContext(c).deleteUploadTokensFromAccount(input.ID); err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
+	app.audit(c, actor.ID, AuditDeleteUploadTokens, accountTarget(input.ID), "")
+
 	c.String(http.StatusOK, "Upload tokens deleted")
 }
 
 type adminGiveInviteCodeInput struct {
-	ID   uint `form:"id"`
-	Uses uint `form:"uses,default=5"` // How many uses the invite code has
+	ID          uint   `form:"id"`
+	Uses        uint   `form:"uses,default=5"`            // How many uses the invite code has
+	AccountType string `form:"account_type,default=USER"` // Role the invited accounts get
 }
 
-// TODO: allow giving admin account invites
 func (app *Application) adminGiveInviteCode(c *gin.Context) {
 	var (
 		input adminGiveInviteCodeInput
@@ -138,12 +163,147 @@ func (app *Application) adminGiveInviteCode(c *gin.Context) {
 		return
 	}
 
-	inviteCode, err := app.db.createInviteCode(input.Uses, "USER", input.ID)
+	role := Role(input.AccountType)
+	if !role.Valid() {
+		c.AbortWithError(http.StatusBadRequest, ErrInvalidRole)
+		return
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	inviteCode, err := app.db.withContext(c).createInviteCode(input.Uses, role, input.ID)
 	if err != nil {
-		log.Err(err).Msg("Failed to create invite code")
+		log.Ctx(c).Err(err).Msg("Failed to create invite code")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	app.audit(c, actor.ID, AuditCreateInviteCode, accountTarget(input.ID), fmt.Sprintf("invite code %d with %d uses for %s", inviteCode.ID, input.Uses, role))
+
 	c.String(http.StatusOK, inviteCode.Code)
 }
+
+type adminSetRoleInput struct {
+	ID          uint   `form:"id"`
+	AccountType string `form:"account_type"`
+}
+
+var ErrCantChangeOwnRole = fmt.Errorf("you can't change your own role")
+
+func (app *Application) adminSetRole(c *gin.Context) {
+	var (
+		input adminSetRoleInput
+		err   error
+	)
+
+	if err = c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	role := Role(input.AccountType)
+	if !role.Valid() {
+		c.AbortWithError(http.StatusBadRequest, ErrInvalidRole)
+		return
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// Prevents admins from locking themselves out
+	if actor.ID == input.ID {
+		c.AbortWithError(http.StatusBadRequest, ErrCantChangeOwnRole)
+		return
+	}
+
+	if err = app.db.withContext(c).setAccountRole(input.ID, role); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to set account role")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditSetRole, accountTarget(input.ID), string(role))
+
+	c.String(http.StatusOK, fmt.Sprintf("User %d is now %s", input.ID, role))
+}
+
+// Empty values reset the quota back to the role default
+type adminSetQuotaInput struct {
+	ID           uint   `form:"id"`
+	MaxStorage   string `form:"max_storage"`   // e.g "10 GB", 0 for unlimited
+	MaxFiles     string `form:"max_files"`     // 0 for unlimited
+	MaxRetention string `form:"max_retention"` // e.g "720h", 0 for unlimited
+}
+
+func (app *Application) adminSetQuota(c *gin.Context) {
+	var (
+		input adminSetQuotaInput
+		err   error
+	)
+
+	if err = c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	var (
+		maxStorage   *uint
+		maxFiles     *uint
+		maxRetention *time.Duration
+	)
+
+	if input.MaxStorage != "" {
+		bytes, err := humanize.ParseBytes(input.MaxStorage)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid max storage")
+			return
+		}
+
+		value := uint(bytes)
+		maxStorage = &value
+	}
+
+	if input.MaxFiles != "" {
+		files, err := strconv.ParseUint(input.MaxFiles, 10, 0)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid max files")
+			return
+		}
+
+		value := uint(files)
+		maxFiles = &value
+	}
+
+	if input.MaxRetention != "" {
+		value, err := time.ParseDuration(input.MaxRetention)
+		if err != nil || value < 0 {
+			c.String(http.StatusBadRequest, "Invalid max retention")
+			return
+		}
+
+		maxRetention = &value
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = app.db.withContext(c).setAccountQuota(input.ID, maxStorage, maxFiles, maxRetention); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to set account quota")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditSetQuota, accountTarget(input.ID), fmt.Sprintf("max_storage=%q max_files=%q max_retention=%q", input.MaxStorage, input.MaxFiles, input.MaxRetention))
+
+	c.String(http.StatusOK, fmt.Sprintf("Quota for user %d updated", input.ID))
+}
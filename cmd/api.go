@@ -1,18 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
@@ -25,54 +24,53 @@ func (app *Application) accountDeleteAPI(c *gin.Context) {
 		return
 	}
 
-	account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID))
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to fetch user by session token")
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	if err = app.deleteAccount(account.ID); err != nil {
-		log.Err(err).Msg("Failed to delete own account")
+	if err = app.deleteAccount(c, account.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to delete own account")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	app.audit(c, account.ID, AuditDeleteAccount, accountTarget(account.ID), "")
+
 	c.String(http.StatusOK, "Account deleted successfully")
 }
 
-func (app *Application) deleteAccount(userID uint) (err error) {
-	if err = app.db.deleteSessionTokensFromAccount(userID); err != nil {
+func (app *Application) deleteAccount(ctx context.Context, userID uint) (err error) {
+	if err = app.db.withContext(ctx).deleteSessionTokensFromAccount(userID); err != nil {
 		return
 	}
 
-	if err = app.db.deleteUploadTokensFromAccount(userID); err != nil {
+	if err = app.db.withContext(ctx).deleteUploadTokensFromAccount(userID); err != nil {
 		return
 	}
 
-	if err = app.db.deleteInviteCodesFromAccount(userID); err != nil {
+	if err = app.db.withContext(ctx).deleteInviteCodesFromAccount(userID); err != nil {
 		return
 	}
 
-	files, err := app.db.getAllFilesFromAccount(userID)
-	if err != nil {
+	if err = app.db.withContext(ctx).deleteWebhooksFromAccount(userID); err != nil {
 		return
 	}
 
-	for _, file := range files {
-		if err = app.deleteFile(file.FileName); err != nil {
-			log.Err(err).Msg("Failed to delete file")
-		}
+	if err = app.deleteExportsFromAccount(ctx, userID); err != nil {
+		return
 	}
 
-	if err = app.db.deleteFilesFromAccount(userID); err != nil {
+	if err = app.deleteFilesFromAccount(ctx, userID); err != nil {
 		return
 	}
 
-	if err = app.db.deleteAccount(userID); err != nil {
+	if err = app.db.withContext(ctx).deleteAccount(userID); err != nil {
 		return
 	}
 
@@ -99,45 +97,38 @@ func (app *Application) deleteFileAPI(c *gin.Context) {
 		return
 	}
 
-	rawSessionToken, sessionTokenExists := c.Get("sessionToken")
-	rawUploadToken, uploadTokenExists := c.Get("uploadToken")
-
-	var sessionToken uuid.NullUUID
-	var uploadToken uuid.NullUUID
-	if sessionTokenExists {
-		sessionToken = uuid.NullUUID{UUID: rawSessionToken.(uuid.UUID), Valid: true}
-	} else if uploadTokenExists {
-		uploadToken = uuid.NullUUID{UUID: rawUploadToken.(uuid.UUID), Valid: true}
-	} else {
+	account, err := app.accountFromContext(c)
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, ErrNotAuthenticated) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
-	}
-
-	// Makes sure the file exists
-	var exists bool
-	if exists, err = app.db.fileExists(input.FileName); err != nil {
-		log.Err(err).Msg("Failed to check if file exists")
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to fetch account for file deletion")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
-	} else if !exists {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
 	}
 
-	// Deletes file
-	if err = app.deleteFile(input.FileName); err != nil {
-		log.Err(err).Msg("Failed to delete file")
+	// Makes sure the file exists and belongs to the account, the entry is kept around for the webhook payload
+	file, err := app.db.withContext(c).getFileByName(input.FileName)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && file.UploaderID != account.ID {
+		c.String(http.StatusNotFound, "File not found or you don't own this file")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to check if file exists")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	if err = app.db.deleteFileEntry(input.FileName, uploadToken, sessionToken); err != nil { // Deletes file entry from database
-		log.Err(err).Msg("Failed to delete file entry")
+	// Moves the file entry to the trash, the file itself is deleted once the trash retention runs out
+	if err = app.db.withContext(c).deleteFileEntry(input.FileName, account.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to delete file entry")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	c.String(http.StatusOK, "Successfully deleted the file")
+	app.audit(c, account.ID, AuditDeleteFile, fileTarget(input.FileName), "")
+	app.emitWebhookEvent(c, WebhookFileDeleted, account.ID, app.webhookFileData(file))
+
+	c.String(http.StatusOK, "Moved the file to trash")
 }
 
 // Api for toggling file public/private status
@@ -168,26 +159,28 @@ func (app *Application) toggleFilePublicAPI(c *gin.Context) {
 		return
 	}
 
-	account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID))
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to fetch user by session token")
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	newPublicStatus, err := app.db.toggleFilePublic(input.FileName, account.ID)
+	newPublicStatus, err := app.db.withContext(c).toggleFilePublic(input.FileName, account.ID)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.String(http.StatusNotFound, "File not found or you don't own this file")
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to toggle file public status")
+		log.Ctx(c).Err(err).Msg("Failed to toggle file public status")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	app.audit(c, account.ID, AuditToggleFilePublic, fileTarget(input.FileName), fmt.Sprintf("public: %t", newPublicStatus))
+
 	if newPublicStatus {
 		c.String(http.StatusOK, "File is now public")
 	} else {
@@ -213,7 +206,7 @@ func (app *Application) uploadFileAPI(c *gin.Context) {
 
 	timestamp, exists := c.GetPostForm("expiry_timestamp")
 	if exists {
-		log.Info().Any("expiry_date", timestamp).Msg("Expiry date provided")
+		log.Ctx(c).Info().Any("expiry_date", timestamp).Msg("Expiry date provided")
 		unixSecs, err := strconv.Atoi(timestamp)
 		if err == nil {
 			expiryDate = time.Unix(int64(unixSecs), 0)
@@ -225,6 +218,22 @@ func (app *Application) uploadFileAPI(c *gin.Context) {
 		return
 	}
 
+	account, err := app.accountFromContext(c)
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, ErrNotAuthenticated) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to fetch account for upload")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if expiryDate, err = app.applyRetention(account, expiryDate); errors.Is(err, ErrRetentionExceeded) {
+		_, maxExpiry := app.retentionFor(account)
+		c.String(http.StatusBadRequest, fmt.Sprintf("Expiry can be at most %s from now", maxExpiry))
+		return
+	}
+
 	fileRaw, fileHeader, err := c.Request.FormFile("file")
 	if err != nil {
 		c.String(http.StatusBadRequest, "No file provided")
@@ -233,70 +242,124 @@ func (app *Application) uploadFileAPI(c *gin.Context) {
 	}
 	defer fileRaw.Close()
 
+	// Checked before anything is stored
+	if err = app.checkQuota(c, account, uint(fileHeader.Size)); errors.Is(err, ErrFileLargerThanQuota) {
+		c.String(http.StatusRequestEntityTooLarge, "File is larger than your storage quota")
+		return
+	} else if errors.Is(err, ErrStorageQuotaExceeded) {
+		c.String(http.StatusInsufficientStorage, "Storage quota exceeded, delete some files first")
+		return
+	} else if errors.Is(err, ErrFileQuotaExceeded) {
+		c.String(http.StatusInsufficientStorage, "File count quota exceeded, delete some files first")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to check quota")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
 	originalFileName := fileHeader.Filename
 
 	file, err := io.ReadAll(fileRaw)
 	if err != nil {
-		log.Err(err).Msg("Failed to read uploaded file")
+		log.Ctx(c).Err(err).Msg("Failed to read uploaded file")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	mime := mimetype.Detect(file)
-	fullFileName := app.generateFullFileName(mime)
+	sha256 := hashFile(file)
+	if blocked, found, err := app.checkBlocklist(c, sha256); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to check the hash blocklist")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	} else if found {
+		app.audit(c, account.ID, AuditBlockedUpload, blockedHashTarget(sha256),
+			fmt.Sprintf("%q via %s, blocked for: %s", originalFileName, app.authSource(c), blocked.Reason))
 
-	switch app.config.FileStorageMethod {
-	case fileStorageS3:
-		err = app.uploadFileS3(file, fullFileName)
-	case fileStorageLocal:
-		err = os.WriteFile(filepath.Join(app.config.DataFolder, fullFileName), file, 0o600)
-	default:
-		err = ErrUnknownStorageMethod
+		c.String(http.StatusForbidden, "This file is not allowed to be uploaded")
+		return
 	}
 
-	if err != nil {
-		log.Err(err).Msg("Upload issue")
-		c.AbortWithStatus(http.StatusInternalServerError)
+	_, span := tracer.Start(c, "detect mime type")
+	mime := mimetype.Detect(file)
+	span.End()
+
+	if err = app.checkMimePolicy(account, mime.String(), mime.Extension(), originalFileName); errors.Is(err, ErrMimeTypeNotAllowed) {
+		c.String(http.StatusUnsupportedMediaType, fmt.Sprintf("Files of type %s are not allowed", baseMimeType(mime.String())))
 		return
 	}
 
-	input := CreateFileEntryInput{
-		files: Files{
-			FileName:         fullFileName,
-			OriginalFileName: originalFileName,
-			FileSize:         uint(len(file)),
-			MimeType:         mime.String(),
-			ExpiryDate:       expiryDate,
-			Public:           true,
-		},
+	fullFileName := app.generateFullFileName(mime)
+
+	scanStatus, scan, err := app.scanUpload(c.Request.Context(), file)
+	if errors.Is(err, ErrScannerUnavailable) {
+		c.String(http.StatusServiceUnavailable, "Uploads can't be scanned right now, try again later")
+		return
 	}
 
-	sessionToken, sessionTokenExists := c.Get("sessionToken")
-	uploadToken, uploadTokenExists := c.Get("uploadToken")
+	var moderationStatus string
+	if scan.Infected {
+		details := fmt.Sprintf("%q via %s, detected as %s", originalFileName, app.authSource(c), scan.Signature)
 
-	if sessionTokenExists {
-		input.sessionToken = uuid.NullUUID{
-			UUID:  sessionToken.(uuid.UUID),
-			Valid: true,
-		}
-	} else if uploadTokenExists {
-		input.uploadToken = uuid.NullUUID{
-			UUID:  uploadToken.(uuid.UUID),
-			Valid: true,
+		if app.config.Scanning.Action == scanActionReject {
+			app.audit(c, account.ID, AuditInfectedUpload, noTarget, details+", rejected")
+			c.String(http.StatusUnprocessableEntity, fmt.Sprintf("File was flagged as malware (%s)", scan.Signature))
+			return
 		}
-	} else {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
+
+		app.audit(c, account.ID, AuditInfectedUpload, fileTarget(fullFileName), details+", quarantined")
+		moderationStatus = ModerationQuarantined
 	}
 
-	if err = app.db.createFileEntry(input); errors.Is(err, gorm.ErrRecordNotFound) {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
-	} else if err != nil {
-		log.Err(err).Msg("Failed to create file entry")
+	var scannedAt time.Time
+	if scanStatus != "" {
+		scannedAt = time.Now()
+	}
+
+	if err = app.storeFile(c, file, fullFileName, mime.String(), originalFileName); err != nil {
+		log.Ctx(c).Err(err).Msg("Upload issue")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	c.Redirect(http.StatusTemporaryRedirect, "/"+fullFileName)
+	fileEntry := Files{
+		FileName:         fullFileName,
+		OriginalFileName: originalFileName,
+		FileSize:         uint(len(file)),
+		MimeType:         mime.String(),
+		ExpiryDate:       expiryDate,
+		Public:           true,
+		UploaderID:       account.ID,
+		Sha256:           sha256,
+		ModerationStatus: moderationStatus,
+		ScanStatus:       scanStatus,
+		ScanSignature:    scan.Signature,
+		ScannedAt:        scannedAt,
+	}
+
+	if err = app.db.withContext(c).createFileEntry(fileEntry, app.quotaFor(account)); err != nil {
+		// Parallel uploads can pass the check above together, the entry is only kept when it still fits
+		if deleteErr := app.deleteFile(c, fullFileName); deleteErr != nil {
+			log.Ctx(c).Err(deleteErr).Str("file_name", fullFileName).Msg("Failed to delete file without an entry")
+		}
+
+		if errors.Is(err, ErrStorageQuotaExceeded) {
+			c.String(http.StatusInsufficientStorage, "Storage quota exceeded, delete some files first")
+		} else if errors.Is(err, ErrFileQuotaExceeded) {
+			c.String(http.StatusInsufficientStorage, "File count quota exceeded, delete some files first")
+		} else {
+			log.Ctx(c).Err(err).Msg("Failed to create file entry")
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	app.audit(c, account.ID, AuditUploadFile, fileTarget(fullFileName), fmt.Sprintf("%s, %d bytes", mime.String(), len(file)))
+	recordUpload(mime.String(), len(file))
+	app.emitWebhookEvent(c, WebhookFileUploaded, account.ID, app.webhookFileData(fileEntry))
+
+	// Scripts and ShareX keep this link around, so it can't be a signed one that runs out
+	fileEntry.Uploader = account
+	c.Redirect(http.StatusTemporaryRedirect, app.permanentFileURL(fileEntry))
 }
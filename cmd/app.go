@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/BatteredBunny/hostling/cmd/tags"
 	"github.com/BurntSushi/toml"
@@ -84,6 +85,8 @@ func initializeConfig() (c Config) {
 		log.Fatal().Err(err).Msg("Can't parse config file")
 	}
 
+	c.Logging.apply()
+
 	if c.S3 != (s3Config{}) {
 		c.FileStorageMethod = fileStorageS3
 	} else {
@@ -100,6 +103,47 @@ func initializeConfig() (c Config) {
 		c.PublicUrl = fmt.Sprintf("http://localhost:%s", c.Port)
 	}
 
+	c.prepareContentHost()
+	c.validateTLS()
+
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = 30 * time.Second
+	}
+
+	if c.TrashRetention <= 0 {
+		c.TrashRetention = 7 * 24 * time.Hour
+	}
+
+	if c.Webhooks.Timeout <= 0 {
+		c.Webhooks.Timeout = 10 * time.Second
+	}
+
+	if c.Webhooks.MaxAttempts == 0 {
+		c.Webhooks.MaxAttempts = 10
+	}
+
+	switch c.Scanning.Action {
+	case "":
+		c.Scanning.Action = scanActionReject
+	case scanActionReject, scanActionQuarantine:
+	default:
+		log.Fatal().Msgf("Unknown scanning action %q, has to be reject or quarantine", c.Scanning.Action)
+	}
+
+	if c.Scanning.Timeout <= 0 {
+		c.Scanning.Timeout = 30 * time.Second
+	}
+
+	if c.MimePolicy.Attachment == nil {
+		c.MimePolicy.Attachment = defaultAttachmentTypes
+	}
+
+	for role := range c.Roles {
+		if !role.Valid() {
+			log.Fatal().Err(ErrInvalidRole).Msgf("Unknown role %q in roles config", role)
+		}
+	}
+
 	if c.Branding == "" {
 		c.Branding = "Hostling"
 	} else if len(c.Branding) > 20 {
@@ -1,13 +1,20 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/didip/tollbooth/v8/limiter"
 	"github.com/gin-gonic/gin"
 	"github.com/go-co-op/gocron/v2"
 	"github.com/rs/zerolog/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 type Application struct {
@@ -15,7 +22,11 @@ type Application struct {
 	db          Database
 	s3client    *s3.S3
 	RateLimiter *limiter.Limiter
+	scanner     FileScanner // nil when scanning is disabled
 	cron        gocron.Scheduler
+	tracing     *sdktrace.TracerProvider // nil when tracing is disabled
+
+	storageCheck storageCheckCache
 
 	Router *gin.Engine
 }
@@ -37,11 +48,32 @@ type Config struct {
 	BehindReverseProxy bool   `toml:"behind_reverse_proxy"`
 	TrustedProxy       string `toml:"trusted_proxy"`
 	PublicUrl          string `toml:"public_url"` // URL to use for github callback and cookies
+	ContentUrl         string `toml:"content_url"` // Optional separate origin uploads are served from, e.g https://usercontent.example.com
+	ContentSigningKey  string `toml:"content_signing_key"` // Secret for signed links to private files on the content host
+	contentHost        string
 	Branding           string `toml:"branding"`   // Branding text for toolbar (max 20 characters)
 	Tagline 		   string `toml:"tagline"`    // Used for meta description and text on index page (max 100 characters)
 
 	FileStorageMethod fileStorageMethod
 	S3                s3Config `toml:"s3"`
+
+	Roles     map[Role]roleConfig `toml:"roles"` // Quotas for each role
+	Retention retentionConfig     `toml:"retention"`
+
+	TrashRetention    time.Duration `toml:"trash_retention"`     // How long deleted files can be restored, defaults to a week
+	AuditLogRetention time.Duration `toml:"audit_log_retention"` // How long audit log entries are kept, 0 keeps them forever
+
+	Scanning   scanningConfig   `toml:"scanning"`    // Optional malware scanning of uploads
+	MimePolicy mimePolicyConfig `toml:"mime_policy"` // Which file types can be uploaded and how they are served
+	Metrics    metricsConfig    `toml:"metrics"`     // Prometheus endpoint
+
+	TLS     tlsConfig     `toml:"tls"`     // Serving https without a reverse proxy
+	Logging loggingConfig `toml:"logging"` // Log level, format and how client ips are logged
+	Tracing tracingConfig `toml:"tracing"` // Optional OpenTelemetry tracing
+
+	Webhooks webhooksConfig `toml:"webhooks"` // Delivery settings for the webhooks users and admins set up
+
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"` // How long in-flight requests get to finish on shutdown, defaults to 30 seconds
 }
 
 type s3Config struct {
@@ -53,7 +85,85 @@ type s3Config struct {
 	CdnDomain       string `toml:"cdn_domain"`
 }
 
+// Serves until SIGINT or SIGTERM, then lets in-flight requests finish before stopping the jobs and closing the database
 func (app *Application) Run() {
-	log.Info().Msgf("Starting server at http://localhost:%s", app.config.Port)
-	log.Fatal().Err(http.ListenAndServe(":"+app.config.Port, app.Router)).Msg("HTTP server failed")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tlsConfig, acmeManager := app.serverTLSConfig()
+
+	servers := []*http.Server{{
+		Addr:      ":" + app.config.Port,
+		Handler:   app.Router,
+		TLSConfig: tlsConfig,
+	}}
+
+	if app.config.TLS.RedirectListen != "" {
+		servers = append(servers, app.redirectServer(acmeManager))
+	}
+
+	if app.config.Metrics.Enabled && app.config.Metrics.Listen != "" {
+		servers = append(servers, app.metricsServer())
+	}
+
+	serverErrors := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "") // Certificates come from TLSConfig
+			} else {
+				err = server.ListenAndServe()
+			}
+
+			if !errors.Is(err, http.ErrServerClosed) {
+				serverErrors <- err
+			}
+		}()
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	log.Info().Msgf("Starting server at %s://localhost:%s", scheme, app.config.Port)
+
+	select {
+	case err := <-serverErrors:
+		log.Fatal().Err(err).Msg("HTTP server failed")
+	case <-ctx.Done():
+		stop() // A second signal kills the process right away
+	}
+
+	log.Info().Msgf("Shutting down, waiting up to %s for requests to finish", app.config.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.config.ShutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Err(err).Str("address", server.Addr).Msg("Requests didn't finish in time")
+		}
+	}
+
+	if app.cron != nil {
+		if err := app.cron.Shutdown(); err != nil {
+			log.Err(err).Msg("Failed to stop job scheduler")
+		}
+	}
+
+	if app.tracing != nil {
+		if err := app.tracing.Shutdown(shutdownCtx); err != nil {
+			log.Err(err).Msg("Failed to flush traces")
+		}
+	}
+
+	if sqlDB, err := app.db.DB.DB(); err != nil {
+		log.Err(err).Msg("Failed to get database pool")
+	} else if err = sqlDB.Close(); err != nil {
+		log.Err(err).Msg("Failed to close database")
+	}
+
+	log.Info().Msg("Shutdown complete")
 }
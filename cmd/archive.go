@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+type archiveFormat string
+
+const (
+	archiveZip   archiveFormat = "zip"
+	archiveTarGz archiveFormat = "tar.gz"
+)
+
+var archiveFormats = []archiveFormat{archiveZip, archiveTarGz}
+
+func (f archiveFormat) contentType() string {
+	if f == archiveTarGz {
+		return "application/gzip"
+	}
+
+	return "application/zip"
+}
+
+// Entries are written one after another straight to the underlying writer, so nothing is held in memory
+type archiveWriter interface {
+	add(name string, size int64, modified time.Time, content io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(format archiveFormat, w io.Writer) archiveWriter {
+	if format == archiveTarGz {
+		compressor := gzip.NewWriter(w)
+		return tarGzArchive{tar.NewWriter(compressor), compressor}
+	}
+
+	return zipArchive{zip.NewWriter(w)}
+}
+
+type zipArchive struct {
+	*zip.Writer
+}
+
+// Uploads are mostly compressed already, so they are stored as is
+func (a zipArchive) add(name string, _ int64, modified time.Time, content io.Reader) error {
+	entry, err := a.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+type tarGzArchive struct {
+	tar  *tar.Writer
+	gzip *gzip.Writer
+}
+
+// Tar needs the size before the content, so it has to match what content holds
+func (a tarGzArchive) add(name string, size int64, modified time.Time, content io.Reader) error {
+	if err := a.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modified,
+	}); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(a.tar, content)
+	return err
+}
+
+func (a tarGzArchive) Close() error {
+	return errors.Join(a.tar.Close(), a.gzip.Close())
+}
+
+// Streams the file from storage into the archive
+func (app *Application) addFileToArchive(ctx context.Context, archive archiveWriter, name string, file Files) (err error) {
+	reader, err := app.openFile(ctx, file.FileName)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	return archive.add(name, int64(file.FileSize), file.CreatedAt, reader)
+}
+
+// Keeps archive entry names unique by adding " (n)" before the extension, like browsers do for downloads
+type archiveNames map[string]bool
+
+func (used archiveNames) add(dir, name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = "file"
+	}
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	entry := path.Join(dir, name)
+	for i := 1; used[entry]; i++ {
+		entry = path.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+	}
+
+	used[entry] = true
+	return entry
+}
+
+// Name the file gets inside an archive, the generated name is used for uploads from before original names were kept
+func archiveEntryName(file Files) string {
+	if file.OriginalFileName != "" {
+		return file.OriginalFileName
+	}
+
+	return file.FileName
+}
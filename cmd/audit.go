@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type AuditAction string
+
+const (
+	AuditLogin               AuditAction = "login"
+	AuditLogout              AuditAction = "logout"
+	AuditRegister            AuditAction = "register"
+	AuditLinkGithub          AuditAction = "link_github"
+	AuditDeleteAccount       AuditAction = "delete_account"
+	AuditCreateUploadToken   AuditAction = "create_upload_token"
+	AuditDeleteUploadToken   AuditAction = "delete_upload_token"
+	AuditDeleteInviteCode    AuditAction = "delete_invite_code"
+	AuditTrashAllFiles       AuditAction = "trash_all_files"
+	AuditRestoreFile         AuditAction = "restore_file"
+	AuditToggleFilePublic    AuditAction = "toggle_file_public"
+	AuditUploadFile          AuditAction = "upload_file"
+	AuditDeleteFile          AuditAction = "delete_file"
+	AuditDeleteUser          AuditAction = "delete_user"
+	AuditDeleteUserFiles     AuditAction = "delete_user_files"
+	AuditDeleteSessions      AuditAction = "delete_sessions"
+	AuditDeleteUploadTokens  AuditAction = "delete_upload_tokens"
+	AuditCreateInviteCode    AuditAction = "create_invite_code"
+	AuditSetRole             AuditAction = "set_role"
+	AuditSetQuota            AuditAction = "set_quota"
+	AuditApplyRetention      AuditAction = "apply_retention_policy"
+	AuditExportAuditLog      AuditAction = "export_audit_log"
+	AuditAdminDeleteFile     AuditAction = "admin_delete_file"
+	AuditHideFile            AuditAction = "hide_file"
+	AuditQuarantineFile      AuditAction = "quarantine_file"
+	AuditClearFileModeration AuditAction = "clear_file_moderation"
+	AuditSetFileExpiry       AuditAction = "set_file_expiry"
+	AuditDisableFile         AuditAction = "disable_file"
+	AuditResolveReport       AuditAction = "resolve_report"
+	AuditSuspendUser         AuditAction = "suspend_user"
+	AuditUnsuspendUser       AuditAction = "unsuspend_user"
+	AuditBlockHash           AuditAction = "block_hash"
+	AuditUnblockHash         AuditAction = "unblock_hash"
+	AuditImportBlockedHashes AuditAction = "import_blocked_hashes"
+	AuditBlockedUpload       AuditAction = "blocked_upload"
+	AuditInfectedUpload      AuditAction = "infected_upload"
+	AuditCreateWebhook       AuditAction = "create_webhook"
+	AuditDeleteWebhook       AuditAction = "delete_webhook"
+	AuditRequestExport       AuditAction = "request_export"
+	AuditDownloadExport      AuditAction = "download_export"
+	AuditBulkFileAction      AuditAction = "bulk_file_action"
+)
+
+var AuditActions = []AuditAction{
+	AuditLogin,
+	AuditLogout,
+	AuditRegister,
+	AuditLinkGithub,
+	AuditDeleteAccount,
+	AuditCreateUploadToken,
+	AuditDeleteUploadToken,
+	AuditDeleteInviteCode,
+	AuditTrashAllFiles,
+	AuditRestoreFile,
+	AuditToggleFilePublic,
+	AuditUploadFile,
+	AuditDeleteFile,
+	AuditDeleteUser,
+	AuditDeleteUserFiles,
+	AuditDeleteSessions,
+	AuditDeleteUploadTokens,
+	AuditCreateInviteCode,
+	AuditSetRole,
+	AuditSetQuota,
+	AuditApplyRetention,
+	AuditExportAuditLog,
+	AuditAdminDeleteFile,
+	AuditHideFile,
+	AuditQuarantineFile,
+	AuditClearFileModeration,
+	AuditSetFileExpiry,
+	AuditDisableFile,
+	AuditResolveReport,
+	AuditSuspendUser,
+	AuditUnsuspendUser,
+	AuditBlockHash,
+	AuditUnblockHash,
+	AuditImportBlockedHashes,
+	AuditBlockedUpload,
+	AuditInfectedUpload,
+	AuditCreateWebhook,
+	AuditDeleteWebhook,
+	AuditRequestExport,
+	AuditDownloadExport,
+	AuditBulkFileAction,
+}
+
+// Entries are only ever inserted, CleanUpJob is the only thing deleting them once the retention runs out
+type AuditLogs struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+
+	ActorID uint        `gorm:"index"` // Account that did the action
+	Action  AuditAction `gorm:"index"`
+
+	TargetType string `gorm:"index:,composite:target"` // e.g "account" or "file"
+	TargetID   string `gorm:"index:,composite:target"`
+
+	IpHash  string
+	Details string
+}
+
+var ErrAuditLogAppendOnly = errors.New("audit log entries can't be modified")
+
+func (AuditLogs) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditLogAppendOnly
+}
+
+type auditTarget struct {
+	Type string
+	ID   string
+}
+
+var noTarget = auditTarget{}
+
+func accountTarget(id uint) auditTarget {
+	return auditTarget{Type: "account", ID: strconv.FormatUint(uint64(id), 10)}
+}
+
+func fileTarget(fileName string) auditTarget {
+	return auditTarget{Type: "file", ID: fileName}
+}
+
+func uploadTokenTarget(id uint) auditTarget {
+	return auditTarget{Type: "upload_token", ID: strconv.FormatUint(uint64(id), 10)}
+}
+
+// Failing to write an audit entry is logged but doesn't fail the request
+func (app *Application) audit(c *gin.Context, actorID uint, action AuditAction, target auditTarget, details string) {
+	if err := app.db.withContext(c).createAuditLog(AuditLogs{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: target.Type,
+		TargetID:   target.ID,
+		IpHash:     app.ipHash(c.ClientIP()),
+		Details:    details,
+	}); err != nil {
+		log.Ctx(c).Err(err).Str("action", string(action)).Msg("Failed to write audit log entry")
+	}
+}
+
+type AuditLogFilter struct {
+	ActorID    uint      `form:"actor_id"`
+	Action     string    `form:"action"`
+	TargetType string    `form:"target_type"`
+	TargetID   string    `form:"target_id"`
+	Since      time.Time `form:"since" time_format:"2006-01-02"`
+	Until      time.Time `form:"until" time_format:"2006-01-02"` // Inclusive
+}
+
+type AuditLogApiInput struct {
+	AuditLogFilter
+	Skip uint `form:"skip"`
+}
+
+type AuditLogApiOutput struct {
+	Entries []AuditLogs
+	Count   int64
+}
+
+// Api for browsing the audit log, returns 50 entries at a time
+func (app *Application) adminAuditLogAPI(c *gin.Context) {
+	var input AuditLogApiInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	var (
+		output AuditLogApiOutput
+		err    error
+	)
+
+	if output.Entries, err = app.db.withContext(c).getAuditLogs(input.AuditLogFilter, input.Skip, 50); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get audit log")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if output.Count, err = app.db.withContext(c).countAuditLogs(input.AuditLogFilter); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to count audit log entries")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, output)
+}
+
+type AuditLogExportInput struct {
+	AuditLogFilter
+	Format string `form:"format,default=json"` // json or csv
+}
+
+// Api for exporting every audit log entry matching the filter
+func (app *Application) adminExportAuditLogAPI(c *gin.Context) {
+	var input AuditLogExportInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if input.Format != "json" && input.Format != "csv" {
+		c.String(http.StatusBadRequest, "Format has to be json or csv")
+		return
+	}
+
+	entries, err := app.db.withContext(c).getAuditLogs(input.AuditLogFilter, 0, 0)
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get audit log")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	app.audit(c, account.ID, AuditExportAuditLog, noTarget, fmt.Sprintf("%d entries as %s", len(entries), input.Format))
+
+	fileName := fmt.Sprintf("audit-log-%s.%s", time.Now().Format("2006-01-02"), input.Format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	if input.Format == "json" {
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "ip_hash", "details"})
+	for _, entry := range entries {
+		w.Write([]string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(entry.ActorID), 10),
+			string(entry.Action),
+			entry.TargetType,
+			csvSafe(entry.TargetID),
+			entry.IpHash,
+			csvSafe(entry.Details),
+		})
+	}
+	w.Flush()
+
+	if err = w.Error(); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to write audit log csv")
+	}
+}
+
+// Stops spreadsheet programs from treating user supplied values as formulas
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
@@ -86,32 +86,41 @@ func (app *Application) loginCallback(c *gin.Context) {
 		app.clearLinkingCookie(c)
 
 		if loggedIn && account.GithubID == 0 {
-			if err := app.db.linkGithub(account.ID, user.NickName, user.UserID); err != nil {
+			if err := app.db.withContext(c).linkGithub(account.ID, user.NickName, user.UserID); err != nil {
 				c.String(http.StatusInternalServerError, "Failed to link github")
 				return
 			}
 
+			app.audit(c, account.ID, AuditLinkGithub, accountTarget(account.ID), user.NickName)
+
 			c.Redirect(http.StatusTemporaryRedirect, "/user")
 		} else {
 			c.Redirect(http.StatusTemporaryRedirect, "/login")
 		}
 	} else {
-		account, err := app.db.findAccountByGithubID(user.UserID)
+		account, err := app.db.withContext(c).findAccountByGithubID(user.UserID)
 		if err != nil {
 			c.Redirect(http.StatusTemporaryRedirect, "/login")
 			return
 		}
 
-		if err := app.db.updateGithubUsername(account.ID, user.NickName); err != nil {
-			log.Warn().Err(err).Msg("Failed to update github username")
+		if account.Suspended() {
+			c.String(http.StatusForbidden, account.suspensionMessage())
+			return
+		}
+
+		if err := app.db.withContext(c).updateGithubUsername(account.ID, user.NickName); err != nil {
+			log.Ctx(c).Warn().Err(err).Msg("Failed to update github username")
 		}
 
-		sessionToken, err := app.db.createSessionToken(account.ID)
+		sessionToken, err := app.db.withContext(c).createSessionToken(account.ID)
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
+		app.audit(c, account.ID, AuditLogin, accountTarget(account.ID), provider)
+
 		app.setAuthCookie(sessionToken, c)
 		c.Redirect(http.StatusTemporaryRedirect, "/user")
 	}
@@ -157,7 +166,7 @@ func (app *Application) registerApi(c *gin.Context) {
 		return
 	}
 
-	accountType, invitedBy, err := app.db.useCode(input.Code)
+	accountType, invitedBy, err := app.db.withContext(c).useCode(input.Code)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.String(http.StatusBadRequest, "Invalid code")
 		return
@@ -166,24 +175,31 @@ func (app *Application) registerApi(c *gin.Context) {
 		return
 	}
 
-	acc, err := app.db.createAccount(accountType, invitedBy)
+	acc, err := app.db.withContext(c).createAccount(accountType, invitedBy)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Failed to create account")
 		return
 	}
 
-	token, err := app.db.createSessionToken(acc.ID)
+	token, err := app.db.withContext(c).createSessionToken(acc.ID)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Failed to create account")
 		return
 	}
 
+	app.audit(c, acc.ID, AuditRegister, accountTarget(acc.ID), fmt.Sprintf("%s invited by %d", accountType, invitedBy))
+	app.emitWebhookEvent(c, WebhookAccountRegistered, 0, webhookAccountData{
+		ID:        acc.ID,
+		Role:      acc.AccountType,
+		InvitedBy: acc.InvitedBy,
+	})
+
 	app.setAuthCookie(token, c)
 	c.Redirect(http.StatusTemporaryRedirect, "/user")
 }
 
 func (app *Application) logoutHandler(c *gin.Context) {
-	sessionToken, _, loggedIn, err := app.validateAuthCookie(c)
+	sessionToken, account, loggedIn, err := app.validateAuthCookie(c)
 	if errors.Is(err, ErrInvalidAuthCookie) {
 		c.Redirect(http.StatusTemporaryRedirect, "/")
 		app.clearAuthCookie(c)
@@ -198,10 +214,12 @@ func (app *Application) logoutHandler(c *gin.Context) {
 		return
 	}
 
-	if err = app.db.deleteSession(sessionToken); err != nil {
-		log.Err(err).Msg("Failed to delete session from db")
+	if err = app.db.withContext(c).deleteSession(sessionToken); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to delete session from db")
 	}
 
+	app.audit(c, account.ID, AuditLogout, accountTarget(account.ID), "")
+
 	app.clearAuthCookie(c)
 
 	gothic.Logout(c.Writer, c.Request)
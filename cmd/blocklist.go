@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Content hashes that can't be uploaded again, usually added after abusive content was removed
+type BlockedHashes struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	Sha256 string `gorm:"uniqueIndex"` // Lowercase hex
+	Reason string
+
+	AddedByID uint
+}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var ErrInvalidHash = errors.New("hash has to be a hex encoded sha256")
+
+func hashFile(file []byte) string {
+	sum := sha256.Sum256(file)
+	return hex.EncodeToString(sum[:])
+}
+
+func parseSha256(raw string) (hash string, err error) {
+	hash = strings.ToLower(strings.TrimSpace(raw))
+	if !sha256Pattern.MatchString(hash) {
+		err = ErrInvalidHash
+	}
+
+	return
+}
+
+func blockedHashTarget(hash string) auditTarget {
+	return auditTarget{Type: "blocked_hash", ID: hash}
+}
+
+// Describes how the request was authenticated, e.g "upload token 3"
+func (app *Application) authSource(c *gin.Context) string {
+	uploadToken, exists := c.Get("uploadToken")
+	if !exists {
+		return "session"
+	}
+
+	tokenID, err := app.db.withContext(c).getUploadTokenID(uploadToken.(string))
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find upload token id")
+		return "upload token"
+	}
+
+	return fmt.Sprintf("upload token %d", tokenID)
+}
+
+/*
+Parses an imported hash list, one sha256 per line with an optional note after a comma or whitespace.
+Empty lines and lines starting with # are skipped.
+
+	# exported from somewhere
+	e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855,known spam
+*/
+func parseHashList(list string, defaultReason string, addedByID uint) (hashes []BlockedHashes, invalidLines int) {
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rawHash, note := line, ""
+		if i := strings.IndexAny(line, ", \t"); i >= 0 {
+			rawHash, note = line[:i], line[i+1:]
+		}
+
+		hash, err := parseSha256(rawHash)
+		if err != nil {
+			invalidLines++
+			continue
+		}
+
+		reason := strings.TrimSpace(note)
+		if reason == "" {
+			reason = defaultReason
+		}
+
+		hashes = append(hashes, BlockedHashes{Sha256: hash, Reason: reason, AddedByID: addedByID})
+	}
+
+	return
+}
+
+type AdminBlockedHashesApiInput struct {
+	Search string `form:"search"` // Hash prefix
+	Skip   uint   `form:"skip"`
+}
+
+type AdminBlockedHashesApiOutput struct {
+	Hashes []BlockedHashes
+	Count  int64
+}
+
+// Api for browsing the blocklist, returns 50 hashes at a time
+func (app *Application) adminBlockedHashesAPI(c *gin.Context) {
+	var input AdminBlockedHashesApiInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	search := strings.ToLower(strings.TrimSpace(input.Search))
+
+	var (
+		output AdminBlockedHashesApiOutput
+		err    error
+	)
+
+	if output.Hashes, err = app.db.withContext(c).getBlockedHashes(search, input.Skip, 50); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get blocked hashes")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if output.Count, err = app.db.withContext(c).countBlockedHashes(search); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to count blocked hashes")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, output)
+}
+
+type adminBlockHashInput struct {
+	Sha256 string `form:"sha256"`
+	Reason string `form:"reason"`
+}
+
+// Admin api for blocking a single hash
+func (app *Application) adminBlockHash(c *gin.Context) {
+	var input adminBlockHashInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	hash, err := parseSha256(input.Sha256)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if input.Reason == "" {
+		c.AbortWithError(http.StatusBadRequest, ErrReasonRequired)
+		return
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if _, err = app.db.withContext(c).createBlockedHashes([]BlockedHashes{{Sha256: hash, Reason: input.Reason, AddedByID: actor.ID}}); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to block hash")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditBlockHash, blockedHashTarget(hash), input.Reason)
+
+	c.String(http.StatusOK, "Hash blocked")
+}
+
+// Admin api for blocking the content of an already uploaded file
+func (app *Application) adminBlockFile(c *gin.Context) {
+	var input adminFileActionInput
+	file, actor, ok := app.bindAdminFileAction(c, &input, &input)
+	if !ok {
+		return
+	}
+
+	// Files uploaded before hashes were stored get theirs computed now
+	hash := file.Sha256
+	if hash == "" {
+		content, err := app.readFile(c, file.FileName)
+		if err != nil {
+			log.Ctx(c).Err(err).Msg("Failed to read file for hashing")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		hash = hashFile(content)
+		if err = app.db.withContext(c).setFileSha256(file.ID, hash); err != nil {
+			log.Ctx(c).Err(err).Msg("Failed to store file hash")
+		}
+	}
+
+	if _, err := app.db.withContext(c).createBlockedHashes([]BlockedHashes{{Sha256: hash, Reason: input.Reason, AddedByID: actor.ID}}); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to block hash")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditBlockHash, blockedHashTarget(hash), fmt.Sprintf("from file %s: %s", file.FileName, input.Reason))
+
+	c.String(http.StatusOK, "File content blocked")
+}
+
+type adminUnblockHashInput struct {
+	Sha256 string `form:"sha256"`
+}
+
+func (app *Application) adminUnblockHash(c *gin.Context) {
+	var input adminUnblockHashInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	hash, err := parseSha256(input.Sha256)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = app.db.withContext(c).deleteBlockedHash(hash); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to unblock hash")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditUnblockHash, blockedHashTarget(hash), "")
+
+	c.String(http.StatusOK, "Hash unblocked")
+}
+
+type adminImportBlockedHashesInput struct {
+	Reason string `form:"reason"` // Used for lines without their own note
+}
+
+// Admin api for importing a hash list, see parseHashList for the format
+func (app *Application) adminImportBlockedHashes(c *gin.Context) {
+	var input adminImportBlockedHashesInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if input.Reason == "" {
+		c.AbortWithError(http.StatusBadRequest, ErrReasonRequired)
+		return
+	}
+
+	listFile, listHeader, err := c.Request.FormFile("list")
+	if err != nil {
+		c.String(http.StatusBadRequest, "No hash list provided")
+		return
+	}
+	defer listFile.Close()
+
+	list, err := io.ReadAll(listFile)
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to read hash list")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	hashes, invalidLines := parseHashList(string(list), input.Reason, actor.ID)
+
+	added, err := app.db.withContext(c).createBlockedHashes(hashes)
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to import blocked hashes")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditImportBlockedHashes, noTarget, fmt.Sprintf("%d new hashes from %s: %s", added, listHeader.Filename, input.Reason))
+
+	c.String(http.StatusOK, fmt.Sprintf("Imported %d new hashes, %d already blocked, %d invalid lines skipped", added, int64(len(hashes))-added, invalidLines))
+}
+
+func (app *Application) adminBlocklistPage(c *gin.Context) {
+	_, account, loggedIn, err := app.validateAuthCookie(c)
+	if errors.Is(err, ErrInvalidAuthCookie) {
+		app.clearAuthCookie(c)
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if !loggedIn || !account.AccountType.Can(PermManageBlocklist) {
+		c.Redirect(http.StatusTemporaryRedirect, "/login")
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_blocklist.gohtml", gin.H{
+		"CurrentPage":  "admin",
+		"Branding":     app.config.Branding,
+		"Tagline":      app.config.Tagline,
+		"LoggedIn":     true,
+		"AccountID":    account.ID,
+		"CanViewAdmin": true,
+		"CsrfToken":    app.csrfToken(c),
+		"Role":         account.AccountType,
+	})
+}
+
+// Looks up the hash in the blocklist, found is false when the content is allowed
+func (app *Application) checkBlocklist(ctx context.Context, hash string) (blocked BlockedHashes, found bool, err error) {
+	blocked, err = app.db.withContext(ctx).findBlockedHash(hash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return blocked, false, nil
+	}
+
+	return blocked, err == nil, err
+}
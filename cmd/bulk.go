@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+)
+
+const maxSelectedFileNames = 1000
+
+var (
+	ErrTooManyFileNames     = fmt.Errorf("at most %d file names can be given", maxSelectedFileNames)
+	ErrInvalidVisibility    = errors.New("invalid visibility")
+	ErrInvalidArchiveFormat = errors.New("format has to be zip or tar.gz")
+	ErrInvalidBulkAction    = errors.New("action has to be delete, set_public, set_private or set_expiry")
+	ErrEmptySelection       = errors.New("give file names or a filter, or set all to act on every file")
+)
+
+// Picks files of the calling account, either by name or with the same filters as the admin file browser.
+// Albums don't exist in hostling, so filters are the way to pick a group of files.
+type FileSelection struct {
+	FileConditions
+	FileNames []string `form:"file_names"` // Takes priority over the filter
+}
+
+func (s FileSelection) validate() error {
+	if len(s.FileNames) > maxSelectedFileNames {
+		return ErrTooManyFileNames
+	}
+
+	if !slices.Contains(fileVisibilities, s.Visibility) {
+		return ErrInvalidVisibility
+	}
+
+	return nil
+}
+
+func (s FileSelection) empty() bool {
+	return len(s.FileNames) == 0 && !s.narrows()
+}
+
+// Expired and trashed files are never selected
+func (app *Application) selectFiles(ctx context.Context, account Accounts, selection FileSelection) (files []Files, err error) {
+	if len(selection.FileNames) > 0 {
+		return app.db.withContext(ctx).getFilesFromAccountByNames(account.ID, selection.FileNames)
+	}
+
+	return app.db.withContext(ctx).getFilesFromAccountFiltered(FileFilter{UploaderID: account.ID, FileConditions: selection.FileConditions})
+}
+
+type downloadFilesAPIInput struct {
+	FileSelection
+	Format string `form:"format,default=zip"` // zip or tar.gz
+}
+
+// Api for downloading many files at once, the archive is built while it's sent so its size isn't known up front.
+// Files the uploader can't view because of moderation are left out and counted in the X-Skipped-Files header.
+func (app *Application) downloadFilesAPI(c *gin.Context) {
+	var input downloadFilesAPIInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	format := archiveFormat(input.Format)
+	if !slices.Contains(archiveFormats, format) {
+		c.String(http.StatusBadRequest, ErrInvalidArchiveFormat.Error())
+		return
+	}
+
+	if err := input.validate(); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	files, err := app.selectFiles(c, account, input.FileSelection)
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to select files for download")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var skipped int
+	files = slices.DeleteFunc(files, func(file Files) bool {
+		file.Uploader = account
+		if fileAccessStatus(file, account, true) != http.StatusOK {
+			skipped++
+			return true
+		}
+
+		return false
+	})
+
+	if len(files) == 0 {
+		c.String(http.StatusNotFound, "No files matched")
+		return
+	}
+
+	c.Header("X-Skipped-Files", strconv.Itoa(skipped))
+	c.Header("Content-Type", format.contentType())
+	c.Header("Content-Disposition", attachmentDisposition(fmt.Sprintf("hostling-files-%s.%s", time.Now().Format(time.DateOnly), format)))
+	c.Status(http.StatusOK)
+
+	archive := newArchiveWriter(format, c.Writer)
+	names := archiveNames{}
+
+	// The status is already sent, a failure can only cut the download short
+	for _, file := range files {
+		if err = app.addFileToArchive(c, archive, names.add("", archiveEntryName(file)), file); err != nil {
+			log.Ctx(c).Err(err).Str("file_name", file.FileName).Msg("Failed to add file to download archive")
+			c.Abort()
+			return
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to finish download archive")
+	}
+}
+
+type bulkFileAction string
+
+// There are no albums, so moving files between them isn't one of the actions
+const (
+	bulkDelete     bulkFileAction = "delete" // Moves the files to the trash
+	bulkSetPublic  bulkFileAction = "set_public"
+	bulkSetPrivate bulkFileAction = "set_private"
+	bulkSetExpiry  bulkFileAction = "set_expiry"
+)
+
+var bulkFileActions = []bulkFileAction{bulkDelete, bulkSetPublic, bulkSetPrivate, bulkSetExpiry}
+
+type bulkFilesAPIInput struct {
+	FileSelection
+	Action     string `form:"action"`
+	ExpiryDate string `form:"expiry_date"` // YYYY-MM-DD for set_expiry, empty removes the expiry
+	All        bool   `form:"all"`         // Needed to act on every file when neither names nor filters are given
+}
+
+type bulkFileResult struct {
+	FileName string `json:"file_name"`
+	Ok       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+type bulkFilesAPIOutput struct {
+	Action  bulkFileAction   `json:"action"`
+	Applied int              `json:"applied"`
+	Results []bulkFileResult `json:"results"`
+}
+
+// Api for applying one action to many files, the files either all change or none of them do
+func (app *Application) bulkFilesAPI(c *gin.Context) {
+	var input bulkFilesAPIInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	action := bulkFileAction(input.Action)
+	if !slices.Contains(bulkFileActions, action) {
+		c.String(http.StatusBadRequest, ErrInvalidBulkAction.Error())
+		return
+	}
+
+	if err := input.validate(); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.empty() && !input.All {
+		c.String(http.StatusBadRequest, ErrEmptySelection.Error())
+		return
+	}
+
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var expiryDate time.Time
+	if action == bulkSetExpiry {
+		if input.ExpiryDate != "" {
+			if expiryDate, err = time.Parse("2006-01-02", input.ExpiryDate); err != nil {
+				c.String(http.StatusBadRequest, "Invalid expiry date")
+				return
+			} else if expiryDate.Before(time.Now()) {
+				c.String(http.StatusBadRequest, "Can't specify expiry in the past, sorry.")
+				return
+			}
+		}
+
+		// Same limits as for uploads, so removing the expiry can end up giving the default one
+		if expiryDate, err = app.applyRetention(account, expiryDate); errors.Is(err, ErrRetentionExceeded) {
+			_, maxExpiry := app.retentionFor(account)
+			c.String(http.StatusBadRequest, fmt.Sprintf("Expiry can be at most %s from now", maxExpiry))
+			return
+		}
+	}
+
+	files, err := app.selectFiles(c, account, input.FileSelection)
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to select files for bulk action")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	output := bulkFilesAPIOutput{Action: action, Applied: len(files), Results: []bulkFileResult{}}
+
+	fileIDs := make([]uint, 0, len(files))
+	found := make(map[string]bool, len(files))
+	for _, file := range files {
+		fileIDs = append(fileIDs, file.ID)
+		found[file.FileName] = true
+		output.Results = append(output.Results, bulkFileResult{FileName: file.FileName, Ok: true})
+	}
+
+	// Names that didn't match are reported instead of failing the whole request
+	for _, fileName := range input.FileNames {
+		if !found[fileName] {
+			found[fileName] = true
+			output.Results = append(output.Results, bulkFileResult{FileName: fileName, Error: "File not found or you don't own this file"})
+		}
+	}
+
+	if err = app.db.withContext(c).applyBulkFileAction(account.ID, fileIDs, action, expiryDate); err != nil {
+		log.Ctx(c).Err(err).Str("action", string(action)).Msg("Failed to apply bulk file action")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if len(files) > 0 {
+		details := fmt.Sprintf("%s on %d files", action, len(files))
+		if action == bulkSetExpiry && !expiryDate.IsZero() {
+			details += fmt.Sprintf(" (expires %s)", expiryDate.Format(time.DateOnly))
+		}
+
+		app.audit(c, account.ID, AuditBulkFileAction, accountTarget(account.ID), details)
+	}
+
+	if action == bulkDelete {
+		for _, file := range files {
+			app.emitWebhookEvent(c, WebhookFileDeleted, account.ID, app.webhookFileData(file))
+		}
+	}
+
+	c.JSON(http.StatusOK, output)
+}
@@ -15,6 +15,7 @@ func InitializeApplication() *Application {
 		setupRatelimiting,
 		prepareDB,
 		prepareStorage,
+		prepareScanner,
 
 		wire.Struct(
 			new(uninitializedApplication),
@@ -22,6 +23,7 @@ func InitializeApplication() *Application {
 			"db",
 			"s3client",
 			"RateLimiter",
+			"scanner",
 		),
 
 		setupRouter, // Finishes the setup
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// How long signed links to private files work, they are handed out again every time the ui loads the file
+const signedURLLifetime = 6 * time.Hour
+
+// Validates content_url and fills in the signing key, called from initializeConfig
+func (c *Config) prepareContentHost() {
+	if c.ContentUrl == "" {
+		return
+	}
+
+	contentUrl, err := url.Parse(c.ContentUrl)
+	if err != nil || contentUrl.Host == "" {
+		log.Fatal().Err(err).Msgf("Invalid content_url %q", c.ContentUrl)
+	}
+
+	publicUrl, err := url.Parse(c.PublicUrl)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Invalid public_url %q", c.PublicUrl)
+	}
+
+	if strings.EqualFold(contentUrl.Host, publicUrl.Host) {
+		log.Fatal().Msg("content_url has to be on a different host than public_url")
+	}
+
+	c.ContentUrl = strings.TrimSuffix(c.ContentUrl, "/")
+	c.contentHost = contentUrl.Host
+
+	if c.ContentSigningKey == "" {
+		log.Warn().Msg("No content_signing_key set, links to private files stop working after a restart")
+		c.ContentSigningKey = rand.Text()
+	}
+}
+
+func (app *Application) isContentHost(c *gin.Context) bool {
+	return app.config.contentHost != "" && strings.EqualFold(c.Request.Host, app.config.contentHost)
+}
+
+// The content host only serves uploads, so cookies of the ui never reach user controlled content
+func (app *Application) contentHostMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !app.isContentHost(c) {
+			c.Next()
+			return
+		}
+
+		switch {
+		case c.Request.URL.Path == "/":
+			c.Redirect(http.StatusTemporaryRedirect, app.config.PublicUrl)
+			c.Abort()
+		case c.FullPath() != "" && !strings.HasPrefix(c.Request.URL.Path, "/public/"):
+			c.AbortWithStatus(http.StatusNotFound) // Ui and api routes
+		default:
+			c.Next()
+		}
+	}
+}
+
+// Links to ui pages from responses that can end up being served on the content host
+func (app *Application) uiURL(path string) string {
+	if app.config.ContentUrl == "" {
+		return path
+	}
+
+	return app.config.PublicUrl + path
+}
+
+func (app *Application) signFileName(fileName string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(app.config.ContentSigningKey))
+	fmt.Fprintf(mac, "%s\n%d", fileName, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Link to the file as seen by viewer, a zero account is an anonymous viewer.
+// Files needing a login get a signed link on the content host when the viewer may see them, since the auth cookie isn't sent there.
+func (app *Application) fileURL(file Files, viewer Accounts) string {
+	if app.config.ContentUrl == "" {
+		return "/" + file.FileName
+	}
+
+	if file.Public && file.ModerationStatus == "" && !file.Uploader.FilesHidden() ||
+		fileAccessStatus(file, viewer, viewer.ID != 0) != http.StatusOK {
+		return app.config.ContentUrl + "/" + file.FileName
+	}
+
+	return app.signedFileURL(file.FileName)
+}
+
+// Link that doesn't expire, for private files it's the main host one that hands out a fresh signed link after the login check
+func (app *Application) permanentFileURL(file Files) string {
+	if file.Public && file.ModerationStatus == "" && !file.Uploader.FilesHidden() {
+		return app.fileURL(file, Accounts{})
+	}
+
+	return app.uiURL("/" + file.FileName)
+}
+
+// Link that works for any file, only hand these out to moderators or the uploader
+func (app *Application) signedFileURL(fileName string) string {
+	if app.config.ContentUrl == "" {
+		return "/" + fileName
+	}
+
+	expires := time.Now().Add(signedURLLifetime).Unix()
+	return fmt.Sprintf("%s/%s?expires=%d&signature=%s", app.config.ContentUrl, fileName, expires, app.signFileName(fileName, expires))
+}
+
+// Signed links skip the login check in indexFiles
+func (app *Application) validFileSignature(c *gin.Context, fileName string) bool {
+	if app.config.ContentUrl == "" {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	expected := app.signFileName(fileName, expires)
+	return hmac.Equal([]byte(expected), []byte(c.Query("signature")))
+}
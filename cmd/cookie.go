@@ -1,15 +1,17 @@
 package cmd
 
 import (
+	"crypto/rand"
 	"errors"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 const AUTH_COOKIE = "auth"
 const LINKING_COOKIE = "linking"
+const CSRF_COOKIE = "csrf_token"
 
 func (app *Application) setLinkingCookie(c *gin.Context) {
 	c.SetCookie("linking", "true", 500, "/", app.config.PublicUrl, gin.Mode() == gin.ReleaseMode, true)
@@ -19,18 +21,33 @@ func (app *Application) clearLinkingCookie(c *gin.Context) {
 	c.SetCookie("linking", "", -1, "/", app.config.PublicUrl, gin.Mode() == gin.ReleaseMode, true)
 }
 
-func (app *Application) setAuthCookie(sessionToken uuid.UUID, c *gin.Context) {
+func (app *Application) setAuthCookie(sessionToken string, c *gin.Context) {
 	// TODO: use actual max age
-	c.SetCookie(AUTH_COOKIE, sessionToken.String(), 86400*7, "/", app.config.PublicUrl, gin.Mode() == gin.ReleaseMode, true)
+	c.SetCookie(AUTH_COOKIE, sessionToken, 86400*7, "/", app.config.PublicUrl, gin.Mode() == gin.ReleaseMode, true)
 }
 
 func (app *Application) clearAuthCookie(c *gin.Context) {
 	c.SetCookie(AUTH_COOKIE, "", -1, "/", app.config.PublicUrl, gin.Mode() == gin.ReleaseMode, true)
 }
 
+// Returns the csrf token for the double-submit check, setting the cookie if its missing
+func (app *Application) csrfToken(c *gin.Context) string {
+	if token, err := c.Cookie(CSRF_COOKIE); err == nil && token != "" {
+		return token
+	}
+
+	token := rand.Text()
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(CSRF_COOKIE, token, 86400*7, "/", app.config.PublicUrl, gin.Mode() == gin.ReleaseMode, true)
+	c.SetSameSite(http.SameSiteDefaultMode)
+
+	return token
+}
+
 var ErrInvalidAuthCookie = errors.New("Invalid session token")
 
-func (app *Application) parseAuthCookie(c *gin.Context) (sessionToken uuid.UUID, err error) {
+func (app *Application) parseAuthCookie(c *gin.Context) (sessionToken string, err error) {
 	rawSessionToken, err := c.Cookie(AUTH_COOKIE)
 	if err != nil {
 		return
@@ -44,7 +61,7 @@ func (app *Application) parseAuthCookie(c *gin.Context) (sessionToken uuid.UUID,
 	return
 }
 
-func (app *Application) validateAuthCookie(c *gin.Context) (sessionToken uuid.UUID, account Accounts, loggedIn bool, err error) {
+func (app *Application) validateAuthCookie(c *gin.Context) (sessionToken string, account Accounts, loggedIn bool, err error) {
 	sessionToken, err = app.parseAuthCookie(c)
 	if err != nil {
 		err = ErrInvalidAuthCookie
@@ -52,7 +69,7 @@ func (app *Application) validateAuthCookie(c *gin.Context) (sessionToken uuid.UU
 		return
 	}
 
-	if account, err = app.db.getAccountBySessionToken(sessionToken); errors.Is(err, gorm.ErrRecordNotFound) {
+	if account, err = app.db.withContext(c).getAccountBySessionToken(sessionToken); errors.Is(err, gorm.ErrRecordNotFound) {
 		err = ErrInvalidAuthCookie
 		app.clearAuthCookie(c)
 		return
@@ -61,6 +78,7 @@ func (app *Application) validateAuthCookie(c *gin.Context) (sessionToken uuid.UU
 	}
 
 	loggedIn = true
+	c.Set("account", account)
 
 	return
 }
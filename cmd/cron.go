@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"time"
 
 	"github.com/go-co-op/gocron/v2"
@@ -13,9 +14,28 @@ func (app *Application) StartJobScheudler() (err error) {
 		return
 	}
 
+	// Also runs right away, through the scheduler so shutting down waits for it
 	if _, err = app.cron.NewJob(
 		gocron.DurationJob(time.Minute*10),
 		gocron.NewTask(app.CleanUpJob),
+		gocron.WithStartAt(gocron.WithStartImmediately()),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	); err != nil {
+		return
+	}
+
+	if _, err = app.cron.NewJob(
+		gocron.DurationJob(time.Second*15),
+		gocron.NewTask(app.deliverWebhooks),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule), // A slow receiver shouldn't get the same delivery twice
+	); err != nil {
+		return
+	}
+
+	if _, err = app.cron.NewJob(
+		gocron.DurationJob(time.Second*15),
+		gocron.NewTask(app.processExports),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
 	); err != nil {
 		return
 	}
@@ -23,13 +43,14 @@ func (app *Application) StartJobScheudler() (err error) {
 	log.Info().Msg("Successfully setup job scheudler")
 	app.cron.Start()
 
-	go app.CleanUpJob()
-
 	return
 }
 
 func (app *Application) CleanUpJob() {
 	log.Info().Msg("Starting clean up job")
+	defer func(start time.Time) {
+		cleanupJobDuration.Observe(time.Since(start).Seconds())
+	}(time.Now())
 
 	log.Info().Msg("Starting cleaning up expired tokens")
 	if err := app.db.deleteExpiredSessionTokens(); err != nil {
@@ -41,26 +62,60 @@ func (app *Application) CleanUpJob() {
 		log.Err(err).Msg("Failed to delete expired invite codes")
 	}
 
+	if app.config.AuditLogRetention > 0 {
+		log.Info().Msg("Starting cleaning up old audit log entries")
+		if err := app.db.deleteAuditLogsBefore(time.Now().Add(-app.config.AuditLogRetention)); err != nil {
+			log.Err(err).Msg("Failed to delete old audit log entries")
+		}
+	}
+
+	log.Info().Msg("Starting cleaning up expired account exports")
+	app.deleteExpiredExports()
+
+	log.Info().Msg("Starting cleaning up old webhook deliveries")
+	if err := app.db.deleteWebhookDeliveriesBefore(time.Now().Add(-webhookLogRetention)); err != nil {
+		log.Err(err).Msg("Failed to delete old webhook deliveries")
+	}
+
 	files, err := app.db.findExpiredFiles()
 	if err != nil {
 		log.Err(err).Msg("Failed to find expired files")
-		return
-	}
+	} else if len(files) > 0 {
+		log.Info().Msgf("Found %d expired files", len(files))
+		purged := app.purgeFiles(context.Background(), files)
+		cleanupFilesPurged.Add(float64(len(purged)))
 
-	if len(files) == 0 {
-		return
+		for _, file := range purged {
+			app.emitWebhookEvent(context.Background(), WebhookFileExpired, file.UploaderID, app.webhookFileData(file))
+		}
 	}
 
-	log.Info().Msgf("Found %d expired files", len(files))
+	log.Info().Msg("Starting emptying the trash")
+	if files, err = app.db.findTrashedFilesBefore(time.Now().Add(-app.config.TrashRetention)); err != nil {
+		log.Err(err).Msg("Failed to find trashed files")
+	} else if len(files) > 0 {
+		log.Info().Msgf("Found %d files to purge from trash", len(files))
+		cleanupFilesPurged.Add(float64(len(app.purgeFiles(context.Background(), files))))
+	}
+}
 
+// Permanently deletes the files from storage and their database entries, returns the files that were purged
+func (app *Application) purgeFiles(ctx context.Context, files []Files) (purged []Files) {
+	var ids []uint
 	for _, file := range files {
-		if err = app.deleteFile(file.FileName); err != nil {
-			log.Err(err).Msg("Failed to delete file")
+		if err := app.deleteFile(ctx, file.FileName); err != nil {
+			log.Ctx(ctx).Err(err).Str("file_name", file.FileName).Msg("Failed to delete file")
+			continue
 		}
+
+		ids = append(ids, file.ID)
+		purged = append(purged, file)
 	}
 
-	if err = app.db.deleteExpiredFiles(); err != nil {
-		log.Err(err).Msg("Failed to delete file entries in database")
-		return
+	if err := app.db.withContext(ctx).purgeFileEntries(ids); err != nil {
+		log.Ctx(ctx).Err(err).Msg("Failed to delete file entries in database")
+		return nil
 	}
+
+	return purged
 }
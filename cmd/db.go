@@ -1,15 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"crypto/rand"
-	"crypto/sha1"
 	"database/sql"
-	"encoding/hex"
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -21,6 +23,11 @@ type Database struct {
 	*gorm.DB
 }
 
+// Queries made through the returned database are traced as part of the request in ctx
+func (db *Database) withContext(ctx context.Context) *Database {
+	return &Database{db.DB.WithContext(ctx)}
+}
+
 type Accounts struct {
 	gorm.Model
 
@@ -31,7 +38,18 @@ type Accounts struct {
 
 	InvitedBy uint // Account ID of the user who invited this account
 
-	AccountType string // Either "USER" or "ADMIN"
+	AccountType Role
+
+	// Quota overrides set by admins, null means the role default from the config is used and 0 means unlimited
+	MaxStorage   *uint
+	MaxFiles     *uint
+	MaxRetention *time.Duration
+
+	// Suspended accounts can't log in or upload, nothing is deleted so unsuspending restores the account as it was
+	SuspendedAt          time.Time `gorm:"default:null"`
+	SuspendedUntil       time.Time `gorm:"default:null"` // Zero means until unsuspended
+	SuspensionReason     string
+	SuspensionHidesFiles bool // Public files are hidden for the duration of the suspension
 }
 
 type UploadTokens struct {
@@ -42,7 +60,7 @@ type UploadTokens struct {
 	LastUsed *time.Time
 	Nickname string
 
-	Token uuid.UUID `gorm:"uniqueIndex"`
+	TokenHash string `gorm:"uniqueIndex"` // sha256 of the token, the token itself is never stored
 
 	AccountID uint
 	Account   Accounts `gorm:"foreignKey:AccountID"`
@@ -55,7 +73,7 @@ type SessionTokens struct {
 
 	LastUsed   time.Time
 	ExpiryDate time.Time
-	Token      uuid.UUID `gorm:"uniqueIndex"`
+	TokenHash  string `gorm:"uniqueIndex"` // sha256 of the token, the token itself is never stored
 
 	AccountID uint
 	Account   Accounts `gorm:"foreignKey:AccountID"`
@@ -80,8 +98,18 @@ type Files struct {
 
 	ExpiryDate time.Time `gorm:"default:null"` // Time when the file will be deleted
 
+	ModerationStatus string `gorm:"not null;default:''"` // Set by moderators, see ModerationHidden and ModerationQuarantined
+
+	Sha256 string `gorm:"index"` // Hash of the content, empty for files uploaded before hashes were stored
+
+	ScanStatus    string    `gorm:"not null;default:''"` // See ScanClean, ScanInfected and ScanFailed
+	ScanSignature string    // Malware name when the scanner found something
+	ScannedAt     time.Time `gorm:"default:null"`
+
 	UploaderID uint     `json:"-"`
 	Uploader   Accounts `gorm:"foreignKey:UploaderID" json:"-"`
+
+	Url string `gorm:"-"` // Link to view the file, see fileURL
 }
 
 type FileViews struct {
@@ -109,7 +137,7 @@ type InviteCodes struct {
 	Code        string
 	Uses        uint // How many usages of this code is left
 	ExpiryDate  time.Time
-	AccountType string // Either registers normal or admin users
+	AccountType Role // Role the registered account gets
 
 	InviteCreatorID uint     `gorm:"default:null"`
 	InviteCreator   Accounts `gorm:"foreignKey:InviteCreatorID"`
@@ -135,6 +163,16 @@ func prepareDB(c Config) (database Database) {
 		log.Fatal().Err(err).Msg("Failed to open database connection")
 	}
 
+	if err := registerDBMetrics(database.DB); err != nil {
+		log.Fatal().Err(err).Msg("Failed to register database metrics")
+	}
+
+	if c.Tracing.Enabled() {
+		if err := registerDBTracing(database.DB); err != nil {
+			log.Fatal().Err(err).Msg("Failed to register database tracing")
+		}
+	}
+
 	if err := database.DB.AutoMigrate(
 		&Accounts{},
 		&Files{},
@@ -142,10 +180,24 @@ func prepareDB(c Config) (database Database) {
 		&InviteCodes{},
 		&SessionTokens{},
 		&UploadTokens{},
+		&AuditLogs{},
+		&Reports{},
+		&BlockedHashes{},
+		&Webhooks{},
+		&WebhookDeliveries{},
+		&AccountExports{},
 	); err != nil {
 		log.Fatal().Err(err).Msg("Migration failed")
 	}
 
+	if err := database.migrateLegacyTokens(&SessionTokens{}); err != nil {
+		log.Fatal().Err(err).Msg("Failed to migrate legacy session tokens")
+	}
+
+	if err := database.migrateLegacyTokens(&UploadTokens{}); err != nil {
+		log.Fatal().Err(err).Msg("Failed to migrate legacy upload tokens")
+	}
+
 	// Create the first admin user if no user with ID 1 exists
 	userAmount, err := database.accountAmount()
 	if err != nil {
@@ -157,7 +209,7 @@ func prepareDB(c Config) (database Database) {
 	}
 
 	if userAmount == 0 && inviteCodeAmount == 0 {
-		inviteCode, err := database.createInviteCode(1, "ADMIN", 0)
+		inviteCode, err := database.createInviteCode(1, RoleAdmin, 0)
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to create initial invite")
 		}
@@ -168,6 +220,59 @@ func prepareDB(c Config) (database Database) {
 	return
 }
 
+// Tokens used to be stored in plain text in the token column, hashes them and drops the column.
+// The old uuid tokens keep working since the hash is taken from their string form.
+func (db *Database) migrateLegacyTokens(model any) (err error) {
+	if !db.Migrator().HasColumn(model, "token") {
+		return
+	}
+
+	log.Info().Msg("Hashing legacy plain text tokens")
+
+	var legacyTokens []struct {
+		ID    uint
+		Token sql.NullString
+	}
+
+	if err = db.Model(model).
+		Unscoped().
+		Select("id, token").
+		Where("token_hash IS NULL OR token_hash = ''").
+		Scan(&legacyTokens).Error; err != nil {
+		return
+	}
+
+	for _, legacyToken := range legacyTokens {
+		if !legacyToken.Token.Valid {
+			continue
+		}
+
+		if err = db.Model(model).
+			Unscoped().
+			Where("id = ?", legacyToken.ID).
+			Update("token_hash", hashToken(legacyToken.Token.String)).Error; err != nil {
+			return
+		}
+	}
+
+	if db.Migrator().HasIndex(model, "idx_"+db.tableName(model)+"_token") {
+		if err = db.Migrator().DropIndex(model, "idx_"+db.tableName(model)+"_token"); err != nil {
+			return
+		}
+	}
+
+	return db.Migrator().DropColumn(model, "token")
+}
+
+func (db *Database) tableName(model any) string {
+	stmt := &gorm.Statement{DB: db.DB}
+	if err := stmt.Parse(model); err != nil {
+		return ""
+	}
+
+	return stmt.Schema.Table
+}
+
 func (db *Database) getFileViews(fileID uint) (count int64, err error) {
 	err = db.Model(&FileViews{}).
 		Where(&FileViews{FilesID: fileID}).
@@ -219,9 +324,9 @@ func (db *Database) linkGithub(userID uint, username string, rawGithubID string)
 		}).Error
 }
 
-func (db *Database) deleteSession(sessionToken uuid.UUID) (err error) {
+func (db *Database) deleteSession(sessionToken string) (err error) {
 	return db.Model(&SessionTokens{}).
-		Where(&SessionTokens{Token: sessionToken}).
+		Where(&SessionTokens{TokenHash: hashToken(sessionToken)}).
 		Delete(&SessionTokens{}).Error
 }
 
@@ -234,7 +339,7 @@ func (db *Database) inviteCodeAmount() (count int64, err error) {
 	return
 }
 
-func (db *Database) createInviteCode(uses uint, accountType string, inviteCreatorID uint) (inviteCode InviteCodes, err error) {
+func (db *Database) createInviteCode(uses uint, accountType Role, inviteCreatorID uint) (inviteCode InviteCodes, err error) {
 	inviteCode = InviteCodes{
 		Code:            rand.Text(),
 		Uses:            uses,
@@ -257,7 +362,7 @@ func (db *Database) deleteInviteCode(code string, accountID uint) (err error) {
 		Delete(&InviteCodes{}).Error
 }
 
-func (db *Database) useCode(code string) (accountType string, invitedBy uint, err error) {
+func (db *Database) useCode(code string) (accountType Role, invitedBy uint, err error) {
 	var inviteCode InviteCodes
 	if err = db.Model(&InviteCodes{}).
 		Where(&InviteCodes{Code: code}).
@@ -279,151 +384,194 @@ func (db *Database) useCode(code string) (accountType string, invitedBy uint, er
 	return
 }
 
-// Returns the latest time a session token or an upload token was used
-func (db *Database) lastAccountActivity(accountID uint) (lastActivity time.Time, err error) {
-	var (
-		sessionLastUsed sql.NullTime
-		uploadLastUsed  sql.NullTime
-	)
+func (db *Database) getAccountBySessionToken(sessionToken string) (account Accounts, err error) {
+	tokenHash := hashToken(sessionToken)
 
+	var session SessionTokens
 	if err = db.Model(&SessionTokens{}).
-		Where(&SessionTokens{AccountID: accountID}).
-		Select("last_used").
-		Order("last_used DESC").
-		First(&sessionLastUsed).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		return
-	}
-
-	// TODO: somehow hide the error in logs if no upload tokens exist
-	if err = db.Model(&UploadTokens{}).
-		Where(&UploadTokens{AccountID: accountID}).
-		Select("last_used").
-		Order("last_used DESC").
-		First(&uploadLastUsed).Error; errors.Is(err, gorm.ErrRecordNotFound) {
-		err = nil
-	} else if err != nil {
+		Where(&SessionTokens{TokenHash: tokenHash}).
+		Where("expiry_date > ?", time.Now()).
+		First(&session).Error; err != nil {
 		return
 	}
 
-	if !sessionLastUsed.Valid && !uploadLastUsed.Valid {
-		return time.Time{}, nil // No activity found
-	} else if uploadLastUsed.Valid && uploadLastUsed.Time.After(sessionLastUsed.Time) {
-		lastActivity = uploadLastUsed.Time
-	} else {
-		lastActivity = sessionLastUsed.Time
-	}
-
-	return
-}
-
-func (db *Database) getAccountBySessionToken(sessionToken uuid.UUID) (account Accounts, err error) {
 	if err = db.Model(&SessionTokens{}).
-		Where(&SessionTokens{Token: sessionToken}).
-		Where("expiry_date > ?", time.Now()).
+		Where(&SessionTokens{ID: session.ID}).
 		Update("last_used", time.Now()).Error; err != nil {
-		log.Err(err).Msg("Failed to update last used time for session token")
-	}
-
-	var accountID uint
-	if err = db.Model(&SessionTokens{}).
-		Where(&SessionTokens{Token: sessionToken}).
-		Where("expiry_date > ?", time.Now()).
-		Select("account_id").
-		First(&accountID).Error; err != nil {
-		return
+		log.Ctx(db.Statement.Context).Err(err).Msg("Failed to update last used time for session token")
 	}
 
 	err = db.Model(&Accounts{}).
-		Where(&Accounts{ID: accountID}).
+		Where(&Accounts{ID: session.AccountID}).
 		First(&account).Error
 
 	return
 }
 
 // Deletes file entry from database
-func (db *Database) deleteFileEntry(fileName string, uploadToken uuid.NullUUID, sessionToken uuid.NullUUID) (err error) {
-	var account Accounts
-	if uploadToken.Valid {
-		account, err = db.getAccountByUploadToken(uploadToken.UUID)
-		if err != nil {
-			return
-		}
-	} else if sessionToken.Valid {
-		account, err = db.getAccountBySessionToken(sessionToken.UUID)
-		if err != nil {
-			return
-		}
-	} else {
-		// This shouldnt happen but just in case
-		return ErrNotAuthenticated
-	}
-
+func (db *Database) deleteFileEntry(fileName string, accountID uint) (err error) {
 	return db.Model(&Files{}).
-		Where(&Files{FileName: fileName, UploaderID: account.ID}).
+		Where(&Files{FileName: fileName, UploaderID: accountID}).
 		Delete(&Files{}).Error
 }
 
-func (db *Database) getAccountByUploadToken(uploadToken uuid.UUID) (account Accounts, err error) {
-	var accountID uint
+func (db *Database) getAccountByUploadToken(uploadToken string) (account Accounts, err error) {
+	tokenHash := hashToken(uploadToken)
+
+	var token UploadTokens
 	if err = db.Model(&UploadTokens{}).
-		Where(&UploadTokens{Token: uploadToken}).
-		Select("account_id").
-		First(&accountID).Error; err != nil {
+		Where(&UploadTokens{TokenHash: tokenHash}).
+		First(&token).Error; err != nil {
 		return
 	}
 
 	if err = db.Model(&UploadTokens{}).
-		Where(&UploadTokens{Token: uploadToken}).
+		Where(&UploadTokens{ID: token.ID}).
 		Update("last_used", time.Now()).Error; err != nil {
 		return
 	}
 
 	err = db.Model(&Accounts{}).
-		Where(&Accounts{ID: accountID}).
+		Where(&Accounts{ID: token.AccountID}).
 		First(&account).Error
 
 	return
 }
 
-type CreateFileEntryInput struct {
-	files Files
+var ErrNotAuthenticated = errors.New("not authenticated")
 
-	uploadToken  uuid.NullUUID
-	sessionToken uuid.NullUUID
+// Creates file entry in database, the quota is checked again in the same transaction so parallel uploads can't add up past it
+func (db *Database) createFileEntry(file Files, q quota) (err error) {
+	return db.withQuotaCheck(file.UploaderID, q, func(tx *gorm.DB) error {
+		return tx.Model(&Files{}).Create(&file).Error
+	})
 }
 
-var ErrNotAuthenticated = errors.New("not authenticated")
+// Serializes transactions touching the same account, sqlite has no row locks but only allows one writer at a time anyway
+func lockAccount(tx *gorm.DB, accountID uint) error {
+	return tx.Model(&Accounts{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		Where(&Accounts{ID: accountID}).
+		Take(&Accounts{}).Error
+}
 
-// Creates file entry in database
-func (db *Database) createFileEntry(input CreateFileEntryInput) (err error) {
-	var account Accounts
-	if input.sessionToken.Valid {
-		account, err = db.getAccountBySessionToken(input.sessionToken.UUID)
-		if err != nil {
-			return
+// Runs change in a transaction and rolls it back when the account is over its quota afterwards
+func (db *Database) withQuotaCheck(accountID uint, q quota, change func(tx *gorm.DB) error) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockAccount(tx, accountID); err != nil {
+			return err
 		}
-	} else if input.uploadToken.Valid {
-		account, err = db.getAccountByUploadToken(input.uploadToken.UUID)
-		if err != nil {
-			return
+
+		if err := change(tx); err != nil {
+			return err
 		}
-	} else {
-		// This shouldnt happen but just in case
-		return ErrNotAuthenticated
-	}
 
-	input.files.UploaderID = account.ID
+		totalFiles, totalStorage, err := (&Database{tx}).getFileStats(accountID)
+		if err != nil {
+			return err
+		}
 
-	return db.Model(&Files{}).Create(&input.files).Error
+		return q.exceeded(totalFiles, totalStorage)
+	})
 }
 
-// Only deletes database entry, actual file has to be deleted as well
+// Permanently deletes the database entries including trashed ones, actual files have to be deleted as well
 func (db *Database) deleteFilesFromAccount(userID uint) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&FileViews{}).
+			Unscoped().
+			Where("files_id IN (?)", tx.Model(&Files{}).Unscoped().Select("id").Where(&Files{UploaderID: userID})).
+			Delete(&FileViews{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&Files{}).
+			Unscoped().
+			Where(&Files{UploaderID: userID}).
+			Delete(&Files{}).Error
+	})
+}
+
+// Moves all files of the account to the trash
+func (db *Database) trashFilesFromAccount(userID uint) (err error) {
 	return db.Model(&Files{}).
 		Where(&Files{UploaderID: userID}).
 		Delete(&Files{}).Error
 }
 
+// Permanently deletes file entries and their views, actual files have to be deleted as well
+func (db *Database) purgeFileEntries(fileIDs []uint) (err error) {
+	if len(fileIDs) == 0 {
+		return
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&FileViews{}).
+			Unscoped().
+			Where("files_id IN ?", fileIDs).
+			Delete(&FileViews{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&Files{}).
+			Unscoped().
+			Where("id IN ?", fileIDs).
+			Delete(&Files{}).Error
+	})
+}
+
+// Lists files in the accounts trash that were deleted after the given time
+func (db *Database) getTrashedFiles(accountID uint, deletedAfter time.Time) (files []Files, err error) {
+	err = db.Model(&Files{}).
+		Unscoped().
+		Where(&Files{UploaderID: accountID}).
+		Where("deleted_at IS NOT NULL AND deleted_at > ?", deletedAfter).
+		Order("deleted_at DESC").
+		Find(&files).Error
+
+	return
+}
+
+// Finds trashed files of all accounts that were deleted before the given time
+func (db *Database) findTrashedFilesBefore(deletedBefore time.Time) (files []Files, err error) {
+	err = db.Model(&Files{}).
+		Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Find(&files).Error
+
+	return
+}
+
+func (db *Database) getTrashedFile(fileName string, accountID uint, deletedAfter time.Time) (file Files, err error) {
+	err = db.Model(&Files{}).
+		Unscoped().
+		Where(&Files{FileName: fileName, UploaderID: accountID}).
+		Where("deleted_at IS NOT NULL AND deleted_at > ?", deletedAfter).
+		First(&file).Error
+
+	return
+}
+
+// Trashed files are included so moving a file to the trash doesn't get it out of moderation
+func (db *Database) getFileByIDIncludingTrash(fileID uint) (file Files, err error) {
+	err = db.Model(&Files{}).
+		Unscoped().
+		Where(&Files{ID: fileID}).
+		First(&file).Error
+
+	return
+}
+
+func (db *Database) restoreFile(file Files, q quota) (err error) {
+	return db.withQuotaCheck(file.UploaderID, q, func(tx *gorm.DB) error {
+		return tx.Model(&Files{}).
+			Unscoped().
+			Where(&Files{ID: file.ID}).
+			Update("deleted_at", nil).Error
+	})
+}
+
 func (db *Database) deleteSessionTokensFromAccount(userID uint) (err error) {
 	return db.Model(&SessionTokens{}).
 		Where(&SessionTokens{AccountID: userID}).
@@ -471,6 +619,148 @@ func (db *Database) getAccounts() (users []Accounts, err error) {
 	return
 }
 
+// Filters for the admin users api
+type AccountsFilter struct {
+	Search string `form:"search"` // Github username or account ID
+	Role   string `form:"role"`
+	Sort   string `form:"sort,default=id"`
+	Desc   bool   `form:"desc"`
+}
+
+// Columns the admin users api can sort by
+var accountSorts = map[string]string{
+	"id":            "accounts.id",
+	"created_at":    "accounts.created_at",
+	"username":      "accounts.github_username",
+	"role":          "accounts.account_type",
+	"storage":       "space_used",
+	"files":         "files_uploaded",
+	"last_activity": "last_activity",
+}
+
+type accountStatsRow struct {
+	Accounts
+
+	SpaceUsed         uint
+	FilesUploaded     int64
+	SessionsCount     int64
+	UploadTokensCount int64
+	LastActivity      aggregateTime
+	InviterUsername   string
+}
+
+// Aggregates like MAX lose the column type in sqlite so the driver returns them as strings
+var sqliteTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+type aggregateTime struct {
+	time.Time
+}
+
+func (t aggregateTime) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
+func (t *aggregateTime) Scan(value any) (err error) {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case time.Time:
+		t.Time = v
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		for _, layout := range sqliteTimeFormats {
+			if t.Time, err = time.Parse(layout, v); err == nil {
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("can't scan %T into time", value)
+	}
+
+	return
+}
+
+// Makes % and _ in user input match literally, the query has to use ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (f AccountsFilter) apply(tx *gorm.DB) *gorm.DB {
+	tx = tx.Where("accounts.deleted_at IS NULL")
+
+	if f.Search != "" {
+		username := "%" + escapeLike(strings.ToLower(f.Search)) + "%"
+		if id, err := strconv.ParseUint(f.Search, 10, 0); err == nil {
+			tx = tx.Where(`accounts.id = ? OR LOWER(accounts.github_username) LIKE ? ESCAPE '\'`, id, username)
+		} else {
+			tx = tx.Where(`LOWER(accounts.github_username) LIKE ? ESCAPE '\'`, username)
+		}
+	}
+
+	if f.Role != "" {
+		tx = tx.Where("accounts.account_type = ?", f.Role)
+	}
+
+	return tx
+}
+
+// All the stats are collected with one query instead of querying every account separately
+func (db *Database) getAccountStats(filter AccountsFilter, skip, limit uint) (rows []accountStatsRow, err error) {
+	now := time.Now()
+
+	files := db.Model(&Files{}).
+		Select("uploader_id, COALESCE(SUM(file_size), 0) AS space_used, COUNT(*) AS files_uploaded").
+		Where("(expiry_date is not null AND expiry_date > ?) OR expiry_date is null", now). // Filters expired files
+		Group("uploader_id")
+
+	sessions := db.Model(&SessionTokens{}).
+		Select("account_id, COUNT(CASE WHEN expiry_date > ? THEN 1 END) AS sessions_count, MAX(last_used) AS last_used", now).
+		Group("account_id")
+
+	uploadTokens := db.Model(&UploadTokens{}).
+		Select("account_id, COUNT(*) AS upload_tokens_count, MAX(last_used) AS last_used").
+		Group("account_id")
+
+	order := accountSorts[filter.Sort]
+	if filter.Desc {
+		order += " DESC"
+	}
+
+	err = filter.apply(db.Table("accounts")).
+		Select(`accounts.*,
+			COALESCE(f.space_used, 0) AS space_used,
+			COALESCE(f.files_uploaded, 0) AS files_uploaded,
+			COALESCE(s.sessions_count, 0) AS sessions_count,
+			COALESCE(u.upload_tokens_count, 0) AS upload_tokens_count,
+			CASE WHEN u.last_used IS NULL OR s.last_used > u.last_used THEN s.last_used ELSE u.last_used END AS last_activity,
+			inviter.github_username AS inviter_username`).
+		Joins("LEFT JOIN (?) AS f ON f.uploader_id = accounts.id", files).
+		Joins("LEFT JOIN (?) AS s ON s.account_id = accounts.id", sessions).
+		Joins("LEFT JOIN (?) AS u ON u.account_id = accounts.id", uploadTokens).
+		Joins("LEFT JOIN accounts AS inviter ON inviter.id = accounts.invited_by").
+		Order(order).
+		Order("accounts.id").
+		Offset(int(skip)).
+		Limit(int(limit)).
+		Scan(&rows).Error
+
+	return
+}
+
+func (db *Database) countAccounts(filter AccountsFilter) (count int64, err error) {
+	err = filter.apply(db.Table("accounts")).Count(&count).Error
+
+	return
+}
+
 func (db *Database) filesAmountOnAccount(accountID uint) (count int64, err error) {
 	err = db.Model(&Files{}).
 		Where(&Files{UploaderID: accountID}).
@@ -480,6 +770,15 @@ func (db *Database) filesAmountOnAccount(accountID uint) (count int64, err error
 	return
 }
 
+func (db *Database) getAllFilesFromAccountIncludingTrash(userID uint) (files []Files, err error) {
+	err = db.Model(&Files{}).
+		Unscoped().
+		Where(&Files{UploaderID: userID}).
+		Find(&files).Error
+
+	return
+}
+
 func (db *Database) getAllFilesFromAccount(userID uint) (files []Files, err error) {
 	err = db.Model(&Files{}).
 		Where(&Files{UploaderID: userID}).
@@ -489,6 +788,25 @@ func (db *Database) getAllFilesFromAccount(userID uint) (files []Files, err erro
 	return
 }
 
+// Updates expiry dates of files by ID in one transaction
+func (db *Database) setFileExpiries(expiries map[uint]time.Time) (err error) {
+	if len(expiries) == 0 {
+		return
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for fileID, expiryDate := range expiries {
+			if err := tx.Model(&Files{}).
+				Where(&Files{ID: fileID}).
+				Update("expiry_date", expiryDate).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 func (db *Database) getFileStats(userID uint) (totalFiles uint, totalStorage uint, err error) {
 	var result struct {
 		TotalFiles   uint
@@ -518,49 +836,18 @@ func (db *Database) getAccountByID(accountID uint) (account Accounts, err error)
 	return
 }
 
-func (db *Database) getSessionsCount(accountID uint) (count int64, err error) {
-	err = db.Model(&SessionTokens{}).
-		Where(&SessionTokens{AccountID: accountID}).
-		Where("expiry_date > ?", time.Now()).
-		Count(&count).Error
-
-	return
-}
-
-func (db *Database) getUploadTokensCount(accountID uint) (count int64, err error) {
-	err = db.Model(&UploadTokens{}).
-		Where(&UploadTokens{AccountID: accountID}).
-		Count(&count).Error
-
-	return
-}
-
-// Looks if file exists in database
-func (db *Database) fileExists(fileName string) (bool, error) {
-	var count int64
-	if err := db.Model(&Files{}).
-		Where(&Files{FileName: fileName}).
-		Where("(expiry_date is not null AND expiry_date > ?) OR expiry_date is null", time.Now()).
-		Count(&count).Error; err != nil {
-		return false, err
-	}
-
-	return count > 0, nil
-}
-
 func (db *Database) getFileByName(fileName string) (file Files, err error) {
 	err = db.Model(&Files{}).
+		Joins("Uploader"). // Needed to know if the uploader is suspended
 		Where(&Files{FileName: fileName}).
-		Where("(expiry_date is not null AND expiry_date > ?) OR expiry_date is null", time.Now()).
+		Where("(files.expiry_date is not null AND files.expiry_date > ?) OR files.expiry_date is null", time.Now()).
 		First(&file).Error
 
 	return
 }
 
 func (db *Database) bumpFileViews(fileName string, ip string) (err error) {
-	h := sha1.New()
-	h.Write([]byte(ip))
-	ipHash := hex.EncodeToString(h.Sum(nil))
+	ipHash := hashIP(ip)
 
 	var fileID uint
 	if err = db.Model(&Files{}).
@@ -578,29 +865,27 @@ func (db *Database) bumpFileViews(fileName string, ip string) (err error) {
 		}).Error
 }
 
-func (db *Database) createSessionToken(userID uint) (sessionToken uuid.UUID, err error) {
+func (db *Database) createSessionToken(userID uint) (sessionToken string, err error) {
 	log.Debug().Msgf("Creating session token for account %d", userID)
 
+	sessionToken = generateToken(sessionTokenPrefix)
+
 	session := SessionTokens{
 		AccountID:  userID,
-		Token:      uuid.New(),
+		TokenHash:  hashToken(sessionToken),
 		ExpiryDate: time.Now().Add(time.Hour * 24 * 7), // A week from now
 		LastUsed:   time.Now(),
 	}
 
 	if err = db.Model(&SessionTokens{}).Create(&session).Error; err != nil {
-		return
+		sessionToken = ""
 	}
 
-	sessionToken = session.Token
-
 	return
 }
 
-var ErrInvalidAccountType = errors.New("Invalid account type specified")
-
-func (db *Database) createAccount(accountType string, invitedBy uint) (account Accounts, err error) {
-	if accountType == "ADMIN" || accountType == "USER" {
+func (db *Database) createAccount(accountType Role, invitedBy uint) (account Accounts, err error) {
+	if accountType.Valid() {
 		account = Accounts{
 			AccountType: accountType,
 			InvitedBy:   invitedBy,
@@ -608,46 +893,92 @@ func (db *Database) createAccount(accountType string, invitedBy uint) (account A
 
 		err = db.Model(&Accounts{}).Create(&account).Error
 	} else {
-		err = ErrInvalidAccountType
+		err = ErrInvalidRole
 	}
 
 	return
 }
 
+func (db *Database) setAccountQuota(accountID uint, maxStorage *uint, maxFiles *uint, maxRetention *time.Duration) (err error) {
+	return db.Model(&Accounts{}).
+		Where(&Accounts{ID: accountID}).
+		Updates(map[string]interface{}{
+			"max_storage":   maxStorage,
+			"max_files":     maxFiles,
+			"max_retention": maxRetention,
+		}).Error
+}
+
+func (db *Database) suspendAccount(accountID uint, reason string, until time.Time, hideFiles bool) (err error) {
+	var suspendedUntil any
+	if !until.IsZero() {
+		suspendedUntil = until
+	}
+
+	return db.Model(&Accounts{}).
+		Where(&Accounts{ID: accountID}).
+		Updates(map[string]any{
+			"suspended_at":           time.Now(),
+			"suspended_until":        suspendedUntil,
+			"suspension_reason":      reason,
+			"suspension_hides_files": hideFiles,
+		}).Error
+}
+
+func (db *Database) unsuspendAccount(accountID uint) (err error) {
+	return db.Model(&Accounts{}).
+		Where(&Accounts{ID: accountID}).
+		Updates(map[string]any{
+			"suspended_at":           nil,
+			"suspended_until":        nil,
+			"suspension_reason":      "",
+			"suspension_hides_files": false,
+		}).Error
+}
+
+func (db *Database) setAccountRole(accountID uint, role Role) (err error) {
+	return db.Model(&Accounts{}).
+		Where(&Accounts{ID: accountID}).
+		Update("account_type", role).Error
+}
+
 type UiUploadToken struct {
-	Token    uuid.UUID
-	Nickname string
-	LastUsed *time.Time
+	ID        uint
+	Nickname  string
+	CreatedAt time.Time
+	LastUsed  *time.Time
 }
 
 func (db *Database) getUploadTokens(userID uint) (uploadTokens []UiUploadToken, err error) {
 	err = db.Model(&UploadTokens{}).
 		Where(&UploadTokens{AccountID: userID}).
-		Select("token, nickname, last_used").
+		Select("id, nickname, created_at, last_used").
 		Scan(&uploadTokens).Error
 
 	return
 }
 
-func (db *Database) createUploadToken(userID uint, nickname string) (uploadToken uuid.UUID, err error) {
-	uploadToken = uuid.New()
+func (db *Database) createUploadToken(userID uint, nickname string) (uploadToken string, err error) {
+	uploadToken = generateToken(uploadTokenPrefix)
 
-	err = db.Model(&UploadTokens{}).
+	if err = db.Model(&UploadTokens{}).
 		Create(&UploadTokens{
 			AccountID: userID,
-			Token:     uploadToken,
+			TokenHash: hashToken(uploadToken),
 			LastUsed:  nil,
 			Nickname:  nickname,
-		}).Error
+		}).Error; err != nil {
+		uploadToken = ""
+	}
 
 	return
 }
 
-func (db *Database) deleteUploadToken(userID uint, uploadToken uuid.UUID) (err error) {
+func (db *Database) deleteUploadToken(userID uint, tokenID uint) (err error) {
 	return db.Model(&UploadTokens{}).
 		Where(&UploadTokens{
 			AccountID: userID,
-			Token:     uploadToken,
+			ID:        tokenID,
 		}).
 		Delete(&UploadTokens{}).Error
 }
@@ -660,18 +991,30 @@ func (db *Database) findExpiredFiles() (files []Files, err error) {
 	return
 }
 
-func (db *Database) deleteExpiredFiles() (err error) {
-	return db.Model(&Files{}).
-		Where("expiry_date is not null AND expiry_date < ?", time.Now()).
-		Delete(&Files{}).Error
-}
-
 func (db *Database) deleteExpiredSessionTokens() (err error) {
 	return db.Model(&SessionTokens{}).
 		Where("expiry_date is not null AND expiry_date < ?", time.Now()).
 		Delete(&SessionTokens{}).Error
 }
 
+func (db *Database) countActiveSessions() (count int64, err error) {
+	err = db.Model(&SessionTokens{}).
+		Where("expiry_date > ?", time.Now()).
+		Count(&count).Error
+
+	return
+}
+
+// Trashed files are still in storage so they are counted too
+func (db *Database) totalStoredBytes() (total uint, err error) {
+	err = db.Model(&Files{}).
+		Unscoped().
+		Select("COALESCE(SUM(file_size), 0)").
+		Scan(&total).Error
+
+	return
+}
+
 func (db *Database) deleteExpiredInviteCodes() (err error) {
 	return db.Model(&InviteCodes{}).
 		Where("expiry_date is not null AND expiry_date < ?", time.Now()).
@@ -719,3 +1062,635 @@ func (db *Database) toggleFilePublic(fileName string, accountID uint) (newPublic
 
 	return
 }
+
+func (db *Database) createAuditLog(entry AuditLogs) (err error) {
+	return db.Model(&AuditLogs{}).Create(&entry).Error
+}
+
+func (f AuditLogFilter) apply(tx *gorm.DB) *gorm.DB {
+	if f.ActorID != 0 {
+		tx = tx.Where("actor_id = ?", f.ActorID)
+	}
+
+	if f.Action != "" {
+		tx = tx.Where("action = ?", f.Action)
+	}
+
+	if f.TargetType != "" {
+		tx = tx.Where("target_type = ?", f.TargetType)
+	}
+
+	if f.TargetID != "" {
+		tx = tx.Where("target_id = ?", f.TargetID)
+	}
+
+	if !f.Since.IsZero() {
+		tx = tx.Where("created_at >= ?", f.Since)
+	}
+
+	if !f.Until.IsZero() {
+		tx = tx.Where("created_at < ?", f.Until.AddDate(0, 0, 1))
+	}
+
+	return tx
+}
+
+// Newest entries first, limit of 0 returns everything
+func (db *Database) getAuditLogs(filter AuditLogFilter, skip, limit uint) (entries []AuditLogs, err error) {
+	tx := filter.apply(db.Model(&AuditLogs{})).
+		Order("created_at DESC, id DESC").
+		Offset(int(skip))
+
+	if limit > 0 {
+		tx = tx.Limit(int(limit))
+	}
+
+	err = tx.Find(&entries).Error
+
+	return
+}
+
+func (db *Database) countAuditLogs(filter AuditLogFilter) (count int64, err error) {
+	err = filter.apply(db.Model(&AuditLogs{})).Count(&count).Error
+
+	return
+}
+
+func (db *Database) deleteAuditLogsBefore(before time.Time) (err error) {
+	return db.Where("created_at < ?", before).Delete(&AuditLogs{}).Error
+}
+
+// File entry as shown in the admin file browser
+type AdminFile struct {
+	FileName         string
+	OriginalFileName string
+	FileSize         uint
+	MimeType         string
+	Public           bool
+	ModerationStatus string
+	ScanStatus       string
+	ScanSignature    string
+	CreatedAt        time.Time
+	ExpiryDate       time.Time
+	UploaderID       uint
+	UploaderUsername string
+	Views            uint
+	Url              string `gorm:"-"`
+}
+
+func (f FileFilter) apply(tx *gorm.DB) *gorm.DB {
+	if f.UploaderID != 0 {
+		tx = tx.Where("files.uploader_id = ?", f.UploaderID)
+	}
+
+	if f.MimeType != "" {
+		if prefix, ok := strings.CutSuffix(f.MimeType, "/*"); ok {
+			tx = tx.Where("files.mime_type LIKE ?", prefix+"/%")
+		} else {
+			// Stored types can have parameters like "; charset=utf-8"
+			tx = tx.Where("files.mime_type = ? OR files.mime_type LIKE ?", f.MimeType, f.MimeType+";%")
+		}
+	}
+
+	if f.MinSize > 0 {
+		tx = tx.Where("files.file_size >= ?", uint(f.MinSize))
+	}
+
+	if f.MaxSize > 0 {
+		tx = tx.Where("files.file_size <= ?", uint(f.MaxSize))
+	}
+
+	if !f.UploadedAfter.IsZero() {
+		tx = tx.Where("files.created_at >= ?", f.UploadedAfter)
+	}
+
+	if !f.UploadedBefore.IsZero() {
+		tx = tx.Where("files.created_at < ?", f.UploadedBefore.AddDate(0, 0, 1))
+	}
+
+	switch f.Visibility {
+	case "public":
+		tx = tx.Where("files.public = ? AND files.moderation_status = ?", true, "")
+	case "private":
+		tx = tx.Where("files.public = ? AND files.moderation_status = ?", false, "")
+	case "hidden":
+		tx = tx.Where("files.moderation_status = ?", ModerationHidden)
+	case "quarantined":
+		tx = tx.Where("files.moderation_status = ?", ModerationQuarantined)
+	case "disabled":
+		tx = tx.Where("files.moderation_status = ?", ModerationDisabled)
+	}
+
+	if f.MinViews != nil {
+		tx = tx.Where("COALESCE(v.views, 0) >= ?", *f.MinViews)
+	}
+
+	if f.MaxViews != nil {
+		tx = tx.Where("COALESCE(v.views, 0) <= ?", *f.MaxViews)
+	}
+
+	return tx
+}
+
+// Files of all accounts with their view counts, expired and trashed files are left out
+func (db *Database) filteredFilesQuery(filter FileFilter) *gorm.DB {
+	views := db.Model(&FileViews{}).
+		Select("files_id, COUNT(*) AS views").
+		Group("files_id")
+
+	return filter.apply(db.Table("files").
+		Joins("LEFT JOIN (?) AS v ON v.files_id = files.id", views).
+		Where("files.deleted_at IS NULL").
+		Where("files.expiry_date IS NULL OR files.expiry_date > ?", time.Now()))
+}
+
+func (db *Database) getFilesFiltered(filter FileFilter, sort string, desc bool, skip, limit uint) (files []AdminFile, err error) {
+	order := adminFileSorts[sort]
+	if desc {
+		order += " DESC"
+	}
+
+	err = db.filteredFilesQuery(filter).
+		Select(`files.file_name, files.original_file_name, files.file_size, files.mime_type, files.public,
+			files.moderation_status, files.scan_status, files.scan_signature, files.created_at, files.expiry_date, files.uploader_id,
+			accounts.github_username AS uploader_username,
+			COALESCE(v.views, 0) AS views`).
+		Joins("LEFT JOIN accounts ON accounts.id = files.uploader_id").
+		Order(order).
+		Order("files.id").
+		Offset(int(skip)).
+		Limit(int(limit)).
+		Scan(&files).Error
+
+	return
+}
+
+func (db *Database) countFilesFiltered(filter FileFilter) (count int64, err error) {
+	err = db.filteredFilesQuery(filter).Count(&count).Error
+
+	return
+}
+
+// Also applies to trashed files, so restoring a file doesn't undo its moderation
+func (db *Database) setFileModerationStatus(fileID uint, status string) (err error) {
+	return db.Model(&Files{}).
+		Unscoped().
+		Where(&Files{ID: fileID}).
+		Update("moderation_status", status).Error
+}
+
+// Zero time removes the expiry
+func (db *Database) setFileExpiry(fileID uint, expiryDate time.Time) (err error) {
+	var value any
+	if !expiryDate.IsZero() {
+		value = expiryDate
+	}
+
+	return db.Model(&Files{}).
+		Where(&Files{ID: fileID}).
+		Update("expiry_date", value).Error
+}
+
+func (db *Database) createReport(report Reports) (err error) {
+	return db.Model(&Reports{}).Create(&report).Error
+}
+
+func (db *Database) getReport(reportID uint) (report Reports, err error) {
+	err = db.Model(&Reports{}).
+		Where(&Reports{ID: reportID}).
+		First(&report).Error
+
+	return
+}
+
+// Empty status matches every report, oldest reports come first so the queue is worked through in order
+func (db *Database) getReports(status ReportStatus, skip, limit uint) (reports []Reports, err error) {
+	tx := db.Model(&Reports{})
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+
+	err = tx.Order("created_at, id").
+		Offset(int(skip)).
+		Limit(int(limit)).
+		Find(&reports).Error
+
+	return
+}
+
+func (db *Database) countReports(status ReportStatus) (count int64, err error) {
+	tx := db.Model(&Reports{})
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+
+	err = tx.Count(&count).Error
+
+	return
+}
+
+func (db *Database) closeReport(reportID uint, status ReportStatus, resolution string, note string, resolvedByID uint) (err error) {
+	return db.Model(&Reports{}).
+		Where("id = ? AND status = ?", reportID, ReportOpen).
+		Updates(map[string]any{
+			"status":          status,
+			"resolution":      resolution,
+			"resolution_note": note,
+			"resolved_by_id":  resolvedByID,
+			"resolved_at":     time.Now(),
+		}).Error
+}
+
+// Resolves every open report of the file at once
+func (db *Database) closeFileReports(fileID uint, resolution string, note string, resolvedByID uint) (err error) {
+	return db.Model(&Reports{}).
+		Where("file_id = ? AND status = ?", fileID, ReportOpen).
+		Updates(map[string]any{
+			"status":          ReportResolved,
+			"resolution":      resolution,
+			"resolution_note": note,
+			"resolved_by_id":  resolvedByID,
+			"resolved_at":     time.Now(),
+		}).Error
+}
+
+func (db *Database) setFileSha256(fileID uint, hash string) (err error) {
+	return db.Model(&Files{}).
+		Where(&Files{ID: fileID}).
+		Update("sha256", hash).Error
+}
+
+func (db *Database) getUploadTokenID(uploadToken string) (tokenID uint, err error) {
+	var token UploadTokens
+	err = db.Model(&UploadTokens{}).
+		Where(&UploadTokens{TokenHash: hashToken(uploadToken)}).
+		First(&token).Error
+
+	return token.ID, err
+}
+
+func (db *Database) findBlockedHash(hash string) (blocked BlockedHashes, err error) {
+	err = db.Model(&BlockedHashes{}).
+		Where(&BlockedHashes{Sha256: hash}).
+		First(&blocked).Error
+
+	return
+}
+
+// Hashes that are already blocked are skipped, returns how many were added
+func (db *Database) createBlockedHashes(hashes []BlockedHashes) (added int64, err error) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	tx := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&hashes, 500)
+
+	return tx.RowsAffected, tx.Error
+}
+
+func (db *Database) deleteBlockedHash(hash string) (err error) {
+	return db.Where(&BlockedHashes{Sha256: hash}).Delete(&BlockedHashes{}).Error
+}
+
+// Search matches the start of the hash
+func (db *Database) getBlockedHashes(search string, skip, limit uint) (hashes []BlockedHashes, err error) {
+	err = db.Model(&BlockedHashes{}).
+		Where("sha256 LIKE ?", search+"%").
+		Order("created_at DESC, id DESC").
+		Offset(int(skip)).
+		Limit(int(limit)).
+		Find(&hashes).Error
+
+	return
+}
+
+func (db *Database) countBlockedHashes(search string) (count int64, err error) {
+	err = db.Model(&BlockedHashes{}).
+		Where("sha256 LIKE ?", search+"%").
+		Count(&count).Error
+
+	return
+}
+
+func (db *Database) createWebhook(webhook Webhooks) (Webhooks, error) {
+	err := db.Model(&Webhooks{}).Create(&webhook).Error
+	return webhook, err
+}
+
+func (db *Database) getWebhook(webhookID uint) (webhook Webhooks, err error) {
+	err = db.Model(&Webhooks{}).
+		Where(&Webhooks{ID: webhookID}).
+		First(&webhook).Error
+
+	return
+}
+
+// Admin webhooks are shared between admins, the others belong to their account
+func (db *Database) getWebhooks(accountID uint, admin bool) (webhooks []Webhooks, err error) {
+	tx := db.Model(&Webhooks{}).Where("admin = ?", admin)
+	if !admin {
+		tx = tx.Where("account_id = ?", accountID)
+	}
+
+	err = tx.Order("id").Find(&webhooks).Error
+
+	return
+}
+
+func (db *Database) countWebhooks(accountID uint, admin bool) (count int64, err error) {
+	tx := db.Model(&Webhooks{}).Where("admin = ?", admin)
+	if !admin {
+		tx = tx.Where("account_id = ?", accountID)
+	}
+
+	err = tx.Count(&count).Error
+
+	return
+}
+
+// Webhooks that get the events of the account, which are its own and the admin ones
+func (db *Database) findWebhooksForAccount(accountID uint) (webhooks []Webhooks, err error) {
+	err = db.Model(&Webhooks{}).
+		Where("admin = ? OR account_id = ?", true, accountID).
+		Find(&webhooks).Error
+
+	return
+}
+
+func (db *Database) deleteWebhook(webhookID uint) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&WebhookDeliveries{WebhookID: webhookID}).Delete(&WebhookDeliveries{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&Webhooks{}, webhookID).Error
+	})
+}
+
+// Admin webhooks are left alone since other admins share them
+func (db *Database) deleteWebhooksFromAccount(accountID uint) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		ids := tx.Model(&Webhooks{}).Select("id").Where("account_id = ? AND admin = ?", accountID, false)
+		if err := tx.Where("webhook_id IN (?)", ids).Delete(&WebhookDeliveries{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("account_id = ? AND admin = ?", accountID, false).Delete(&Webhooks{}).Error
+	})
+}
+
+func (db *Database) createWebhookDeliveries(deliveries []WebhookDeliveries) (err error) {
+	return db.Model(&WebhookDeliveries{}).Create(&deliveries).Error
+}
+
+func (db *Database) getWebhookDelivery(deliveryID uint) (delivery WebhookDeliveries, err error) {
+	err = db.Model(&WebhookDeliveries{}).
+		Where(&WebhookDeliveries{ID: deliveryID}).
+		First(&delivery).Error
+
+	return
+}
+
+func (db *Database) findDueWebhookDeliveries(now time.Time, limit int) (deliveries []WebhookDeliveries, err error) {
+	err = db.Model(&WebhookDeliveries{}).
+		Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+		Order("next_attempt_at, id").
+		Limit(limit).
+		Find(&deliveries).Error
+
+	return
+}
+
+// Saves the outcome of a delivery attempt
+func (db *Database) updateWebhookDelivery(delivery WebhookDeliveries) (err error) {
+	columns := []string{"status", "next_attempt_at", "attempts", "response_status", "last_error"}
+	if !delivery.DeliveredAt.IsZero() {
+		columns = append(columns, "delivered_at")
+	}
+
+	return db.Model(&WebhookDeliveries{}).
+		Where(&WebhookDeliveries{ID: delivery.ID}).
+		Select(columns).
+		Updates(&delivery).Error
+}
+
+// Gives the delivery a fresh set of attempts starting right away
+func (db *Database) requeueWebhookDelivery(deliveryID uint) (err error) {
+	return db.Model(&WebhookDeliveries{}).
+		Where(&WebhookDeliveries{ID: deliveryID}).
+		Updates(map[string]any{
+			"status":          DeliveryPending,
+			"next_attempt_at": time.Now(),
+			"attempts":        0,
+			"last_error":      "",
+		}).Error
+}
+
+// Newest deliveries come first
+func (db *Database) getWebhookDeliveries(webhookID uint, skip, limit uint) (deliveries []WebhookDeliveries, err error) {
+	err = db.Model(&WebhookDeliveries{}).
+		Where(&WebhookDeliveries{WebhookID: webhookID}).
+		Order("created_at DESC, id DESC").
+		Offset(int(skip)).
+		Limit(int(limit)).
+		Find(&deliveries).Error
+
+	return
+}
+
+func (db *Database) countWebhookDeliveries(webhookID uint) (count int64, err error) {
+	err = db.Model(&WebhookDeliveries{}).
+		Where(&WebhookDeliveries{WebhookID: webhookID}).
+		Count(&count).Error
+
+	return
+}
+
+// Pending deliveries are kept no matter how old they are
+func (db *Database) deleteWebhookDeliveriesBefore(before time.Time) (err error) {
+	return db.Model(&WebhookDeliveries{}).
+		Where("status <> ? AND created_at < ?", DeliveryPending, before).
+		Delete(&WebhookDeliveries{}).Error
+}
+
+// View counts by file id, files without views are left out
+func (db *Database) countViewsOfFiles(fileIDs []uint) (views map[uint]int64, err error) {
+	views = make(map[uint]int64)
+	if len(fileIDs) == 0 {
+		return
+	}
+
+	var rows []struct {
+		FilesID uint
+		Views   int64
+	}
+
+	if err = db.Model(&FileViews{}).
+		Select("files_id, COUNT(*) AS views").
+		Where("files_id IN ?", fileIDs).
+		Group("files_id").
+		Scan(&rows).Error; err != nil {
+		return
+	}
+
+	for _, row := range rows {
+		views[row.FilesID] = row.Views
+	}
+
+	return
+}
+
+// Queues a new export in place of the earlier ones, which are returned so their archives can be deleted
+func (db *Database) createExport(accountID uint) (export AccountExports, replaced []AccountExports, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockAccount(tx, accountID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&AccountExports{}).
+			Where(&AccountExports{AccountID: accountID}).
+			Find(&replaced).Error; err != nil {
+			return err
+		}
+
+		for _, previous := range replaced {
+			if previous.Status == ExportPending {
+				return ErrExportInProgress
+			}
+		}
+
+		if err := tx.Where(&AccountExports{AccountID: accountID}).Delete(&AccountExports{}).Error; err != nil {
+			return err
+		}
+
+		export = AccountExports{AccountID: accountID, Status: ExportPending}
+		return tx.Model(&AccountExports{}).Create(&export).Error
+	})
+
+	return
+}
+
+func (db *Database) getExport(exportID uint) (export AccountExports, err error) {
+	err = db.Model(&AccountExports{}).
+		Where(&AccountExports{ID: exportID}).
+		First(&export).Error
+
+	return
+}
+
+func (db *Database) getLatestExport(accountID uint) (export AccountExports, err error) {
+	err = db.Model(&AccountExports{}).
+		Where(&AccountExports{AccountID: accountID}).
+		Order("created_at DESC, id DESC").
+		First(&export).Error
+
+	return
+}
+
+func (db *Database) getExportsFromAccount(accountID uint) (exports []AccountExports, err error) {
+	err = db.Model(&AccountExports{}).
+		Where(&AccountExports{AccountID: accountID}).
+		Find(&exports).Error
+
+	return
+}
+
+// Oldest requests are built first
+func (db *Database) findPendingExports() (exports []AccountExports, err error) {
+	err = db.Model(&AccountExports{}).
+		Where("status = ?", ExportPending).
+		Order("created_at, id").
+		Find(&exports).Error
+
+	return
+}
+
+func (db *Database) finishExport(exportID uint, size int64, expiresAt time.Time) (err error) {
+	return db.Model(&AccountExports{}).
+		Where(&AccountExports{ID: exportID}).
+		Updates(map[string]any{
+			"status":      ExportReady,
+			"file_size":   size,
+			"finished_at": time.Now(),
+			"expires_at":  expiresAt,
+		}).Error
+}
+
+func (db *Database) failExport(exportID uint, reason string) (err error) {
+	return db.Model(&AccountExports{}).
+		Where(&AccountExports{ID: exportID}).
+		Updates(map[string]any{
+			"status":      ExportFailed,
+			"error":       reason,
+			"finished_at": time.Now(),
+		}).Error
+}
+
+// Finished exports whose download link ran out, failed ones are kept until the next request replaces them
+func (db *Database) findExpiredExports(now time.Time) (exports []AccountExports, err error) {
+	err = db.Model(&AccountExports{}).
+		Where("status = ? AND expires_at < ?", ExportReady, now).
+		Find(&exports).Error
+
+	return
+}
+
+func (db *Database) deleteExport(exportID uint) (err error) {
+	return db.Delete(&AccountExports{}, exportID).Error
+}
+
+func (db *Database) deleteExportsFromAccount(accountID uint) (err error) {
+	return db.Where(&AccountExports{AccountID: accountID}).Delete(&AccountExports{}).Error
+}
+
+func (db *Database) getFilesFromAccountByNames(accountID uint, fileNames []string) (files []Files, err error) {
+	err = db.Model(&Files{}).
+		Where("uploader_id = ? AND file_name IN ?", accountID, fileNames).
+		Where("expiry_date IS NULL OR expiry_date > ?", time.Now()).
+		Order("created_at, id").
+		Find(&files).Error
+
+	return
+}
+
+// Same filters as the admin file browser, filter.UploaderID has to be set by the caller
+func (db *Database) getFilesFromAccountFiltered(filter FileFilter) (files []Files, err error) {
+	err = db.filteredFilesQuery(filter).
+		Select("files.*").
+		Order("files.created_at, files.id").
+		Find(&files).Error
+
+	return
+}
+
+// Ids are updated in chunks so big selections stay under the bound parameter limits of the databases
+func (db *Database) applyBulkFileAction(accountID uint, fileIDs []uint, action bulkFileAction, expiryDate time.Time) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for chunk := range slices.Chunk(fileIDs, 500) {
+			query := tx.Model(&Files{}).Where("uploader_id = ? AND id IN ?", accountID, chunk)
+
+			var err error
+			switch action {
+			case bulkDelete:
+				err = query.Delete(&Files{}).Error
+			case bulkSetPublic:
+				err = query.Update("public", true).Error
+			case bulkSetPrivate:
+				err = query.Update("public", false).Error
+			case bulkSetExpiry:
+				var value any
+				if !expiryDate.IsZero() {
+					value = expiryDate
+				}
+
+				err = query.Update("expiry_date", value).Error
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
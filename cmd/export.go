@@ -0,0 +1,424 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "PENDING" // Waiting for the export job to build the archive
+	ExportReady   ExportStatus = "READY"
+	ExportFailed  ExportStatus = "FAILED"
+)
+
+const exportLinkLifetime = 48 * time.Hour // The archive is deleted once the download link runs out
+
+var ErrExportInProgress = errors.New("an export is already being prepared")
+
+// Archive of everything stored about an account, built in the background by processExports
+type AccountExports struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+
+	AccountID uint         `gorm:"index"`
+	Status    ExportStatus `gorm:"index;not null;default:'PENDING'"`
+
+	FileSize   int64
+	Error      string    `json:"-"` // Logged for admins, users only see that it failed
+	FinishedAt time.Time `gorm:"default:null"`
+	ExpiresAt  time.Time `gorm:"default:null"` // Download link stops working
+}
+
+// Written to manifest.json next to the files
+type exportManifest struct {
+	ExportedAt   time.Time              `json:"exported_at"`
+	Account      exportManifestAccount  `json:"account"`
+	Files        []exportManifestFile   `json:"files"`
+	UploadTokens []exportManifestToken  `json:"upload_tokens"`
+	InviteCodes  []exportManifestInvite `json:"invite_codes"`
+}
+
+type exportManifestAccount struct {
+	ID             uint      `json:"id"`
+	Role           Role      `json:"role"`
+	GithubUsername string    `json:"github_username,omitempty"`
+	InvitedBy      uint      `json:"invited_by"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type exportManifestFile struct {
+	Path             string    `json:"path,omitempty"` // Location in the archive, empty when moderation keeps the file from its uploader
+	FileName         string    `json:"file_name"`
+	OriginalFileName string    `json:"original_file_name"`
+	FileSize         uint      `json:"file_size"`
+	MimeType         string    `json:"mime_type"`
+	Sha256           string    `json:"sha256,omitempty"`
+	Public           bool      `json:"public"`
+	Views            int64     `json:"views"`
+	CreatedAt        time.Time `json:"created_at"`
+	ExpiryDate       time.Time `json:"expiry_date,omitzero"`
+	TrashedAt        time.Time `json:"trashed_at,omitzero"`
+	ModerationStatus string    `json:"moderation_status,omitempty"`
+}
+
+type exportManifestToken struct {
+	Nickname  string     `json:"nickname"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used"`
+}
+
+type exportManifestInvite struct {
+	Code       string    `json:"code"`
+	Uses       uint      `json:"uses_left"`
+	Role       Role      `json:"role"`
+	ExpiryDate time.Time `json:"expiry_date"`
+}
+
+func exportTarget(id uint) auditTarget {
+	return auditTarget{Type: "export", ID: strconv.FormatUint(uint64(id), 10)}
+}
+
+// Archives are kept on local disk no matter the storage method, next to the uploads when there is a data folder
+func (app *Application) exportFolder() string {
+	if app.config.DataFolder != "" {
+		return filepath.Join(app.config.DataFolder, "exports")
+	}
+
+	return filepath.Join(os.TempDir(), "hostling-exports")
+}
+
+func (app *Application) exportPath(exportID uint) string {
+	return filepath.Join(app.exportFolder(), fmt.Sprintf("export-%d.zip", exportID))
+}
+
+// Builds the archives of pending exports, run by the job scheduler.
+// An export interrupted by a restart is still pending and gets built again.
+func (app *Application) processExports() {
+	exports, err := app.db.findPendingExports()
+	if err != nil {
+		log.Err(err).Msg("Failed to find pending exports")
+		return
+	}
+
+	for _, export := range exports {
+		start := time.Now()
+		size, err := app.buildExport(context.Background(), export)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Info().Uint("export_id", export.ID).Msg("Export was deleted while it was being built")
+			continue
+		} else if err != nil {
+			log.Err(err).Uint("export_id", export.ID).Uint("account_id", export.AccountID).Msg("Failed to build account export")
+			os.Remove(app.exportPath(export.ID))
+
+			if err = app.db.failExport(export.ID, err.Error()); err != nil {
+				log.Err(err).Uint("export_id", export.ID).Msg("Failed to mark export as failed")
+			}
+
+			continue
+		}
+
+		if err = app.db.finishExport(export.ID, size, time.Now().Add(exportLinkLifetime)); err != nil {
+			log.Err(err).Uint("export_id", export.ID).Msg("Failed to mark export as ready")
+			continue
+		}
+
+		log.Info().Uint("export_id", export.ID).Int64("size", size).Dur("duration", time.Since(start)).Msg("Built account export")
+	}
+}
+
+// Writes the archive to a temporary file first so a half written archive is never offered for download
+func (app *Application) buildExport(ctx context.Context, export AccountExports) (size int64, err error) {
+	if err = os.MkdirAll(app.exportFolder(), 0o700); err != nil {
+		return
+	}
+
+	finalPath := app.exportPath(export.ID)
+	tempPath := finalPath + ".tmp"
+
+	archive, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempPath) // No-op after the rename
+	defer archive.Close()
+
+	if err = app.writeExportArchive(ctx, export.AccountID, archive); err != nil {
+		return
+	}
+
+	info, err := archive.Stat()
+	if err != nil {
+		return
+	}
+
+	if err = archive.Close(); err != nil {
+		return
+	}
+
+	if err = os.Rename(tempPath, finalPath); err != nil {
+		return
+	}
+
+	// The account could have been deleted while the archive was built. Checked after the rename, as deleting the
+	// account removes the archive before the row, so one of the two always gets to remove it.
+	if _, err = app.db.withContext(ctx).getExport(export.ID); err != nil {
+		os.Remove(finalPath)
+		return
+	}
+
+	return info.Size(), nil
+}
+
+func (app *Application) writeExportArchive(ctx context.Context, accountID uint, w io.Writer) (err error) {
+	account, err := app.db.withContext(ctx).getAccountByID(accountID)
+	if err != nil {
+		return
+	}
+
+	files, err := app.db.withContext(ctx).getAllFilesFromAccountIncludingTrash(accountID)
+	if err != nil {
+		return
+	}
+
+	fileIDs := make([]uint, len(files))
+	for i, file := range files {
+		fileIDs[i] = file.ID
+	}
+
+	views, err := app.db.withContext(ctx).countViewsOfFiles(fileIDs)
+	if err != nil {
+		return
+	}
+
+	manifest := exportManifest{
+		ExportedAt: time.Now().UTC(),
+		Account: exportManifestAccount{
+			ID:             account.ID,
+			Role:           account.AccountType,
+			GithubUsername: account.GithubUsername,
+			InvitedBy:      account.InvitedBy,
+			CreatedAt:      account.CreatedAt,
+		},
+		Files:        []exportManifestFile{},
+		UploadTokens: []exportManifestToken{},
+		InviteCodes:  []exportManifestInvite{},
+	}
+
+	archive := newArchiveWriter(archiveZip, w)
+	names := archiveNames{}
+
+	for _, file := range files {
+		// Expired files are only waiting for CleanUpJob to purge them
+		if !file.ExpiryDate.IsZero() && file.ExpiryDate.Before(time.Now()) {
+			continue
+		}
+
+		entry := exportManifestFile{
+			FileName:         file.FileName,
+			OriginalFileName: file.OriginalFileName,
+			FileSize:         file.FileSize,
+			MimeType:         file.MimeType,
+			Sha256:           file.Sha256,
+			Public:           file.Public,
+			Views:            views[file.ID],
+			CreatedAt:        file.CreatedAt,
+			ExpiryDate:       file.ExpiryDate,
+			ModerationStatus: file.ModerationStatus,
+		}
+
+		dir := "files"
+		if file.DeletedAt.Valid {
+			dir = "trash"
+			entry.TrashedAt = file.DeletedAt.Time
+		}
+
+		// The uploader gets what they could view themselves
+		file.Uploader = account
+		if fileAccessStatus(file, account, true) == http.StatusOK {
+			entry.Path = names.add(dir, archiveEntryName(file))
+			if err = app.addFileToArchive(ctx, archive, entry.Path, file); err != nil {
+				return fmt.Errorf("adding %s: %w", file.FileName, err)
+			}
+		}
+
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	tokens, err := app.db.withContext(ctx).getUploadTokens(accountID)
+	if err != nil {
+		return
+	}
+
+	for _, token := range tokens {
+		manifest.UploadTokens = append(manifest.UploadTokens, exportManifestToken{
+			Nickname:  token.Nickname,
+			CreatedAt: token.CreatedAt,
+			LastUsed:  token.LastUsed,
+		})
+	}
+
+	inviteCodes, err := app.db.withContext(ctx).inviteCodesByAccount(accountID)
+	if err != nil {
+		return
+	}
+
+	for _, code := range inviteCodes {
+		manifest.InviteCodes = append(manifest.InviteCodes, exportManifestInvite{
+			Code:       code.Code,
+			Uses:       code.Uses,
+			Role:       code.AccountType,
+			ExpiryDate: code.ExpiryDate,
+		})
+	}
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err = archive.add("manifest.json", int64(len(encoded)), manifest.ExportedAt, bytes.NewReader(encoded)); err != nil {
+		return
+	}
+
+	return archive.Close()
+}
+
+// Deletes the archives of exports whose link ran out, run by CleanUpJob
+func (app *Application) deleteExpiredExports() {
+	exports, err := app.db.findExpiredExports(time.Now())
+	if err != nil {
+		log.Err(err).Msg("Failed to find expired exports")
+		return
+	}
+
+	for _, export := range exports {
+		if err = os.Remove(app.exportPath(export.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Err(err).Uint("export_id", export.ID).Msg("Failed to delete export archive")
+			continue
+		}
+
+		if err = app.db.deleteExport(export.ID); err != nil {
+			log.Err(err).Uint("export_id", export.ID).Msg("Failed to delete export")
+		}
+	}
+}
+
+// Called when the account is deleted
+func (app *Application) deleteExportsFromAccount(ctx context.Context, accountID uint) (err error) {
+	exports, err := app.db.withContext(ctx).getExportsFromAccount(accountID)
+	if err != nil {
+		return
+	}
+
+	for _, export := range exports {
+		if err = os.Remove(app.exportPath(export.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+	}
+
+	return app.db.withContext(ctx).deleteExportsFromAccount(accountID)
+}
+
+// Latest export of the account, null when there is none
+func (app *Application) accountExportAPI(c *gin.Context) {
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	export, err := app.db.withContext(c).getLatestExport(account.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && export.Status == ExportReady && export.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusOK, nil)
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find latest export")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+// Queues a new export, an earlier finished one is replaced
+func (app *Application) requestExportAPI(c *gin.Context) {
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// Checking for a pending export and queueing the new one happen in one transaction, so parallel requests can't both queue one
+	export, replaced, err := app.db.withContext(c).createExport(account.ID)
+	if errors.Is(err, ErrExportInProgress) {
+		c.String(http.StatusConflict, "An export is already being prepared")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to create export")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	for _, previous := range replaced {
+		if err = os.Remove(app.exportPath(previous.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Ctx(c).Err(err).Uint("export_id", previous.ID).Msg("Failed to delete export archive")
+		}
+	}
+
+	app.audit(c, account.ID, AuditRequestExport, exportTarget(export.ID), "")
+
+	c.String(http.StatusOK, "Your export is being prepared, the download link shows up here once it's ready")
+}
+
+type downloadExportAPIInput struct {
+	ID uint `form:"id"`
+}
+
+func (app *Application) downloadExportAPI(c *gin.Context) {
+	var input downloadExportAPIInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	export, err := app.db.withContext(c).getExport(input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && export.AccountID != account.ID {
+		c.String(http.StatusNotFound, "Export not found")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find export")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if export.Status != ExportReady || export.ExpiresAt.Before(time.Now()) {
+		c.String(http.StatusGone, "Export is no longer available")
+		return
+	}
+
+	app.audit(c, account.ID, AuditDownloadExport, exportTarget(export.ID), "")
+
+	c.FileAttachment(app.exportPath(export.ID), fmt.Sprintf("hostling-export-%s.zip", export.CreatedAt.Format(time.DateOnly)))
+}
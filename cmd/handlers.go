@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
 	"path/filepath"
 	"slices"
@@ -13,7 +15,6 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
-	"github.com/google/uuid"
 	"github.com/markbates/goth"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
@@ -39,7 +40,8 @@ func (app *Application) indexPage(c *gin.Context) {
 		// For top bar
 		templateInput["LoggedIn"] = true
 		templateInput["AccountID"] = account.ID
-		templateInput["IsAdmin"] = account.AccountType == "ADMIN"
+		templateInput["CanViewAdmin"] = account.AccountType.Can(PermViewAdmin)
+		templateInput["CsrfToken"] = app.csrfToken(c)
 	}
 
 	c.HTML(http.StatusOK, "index.gohtml", templateInput)
@@ -52,60 +54,94 @@ type AccountStats struct {
 	InvitedBy         string
 	FilesUploaded     int64
 	You               bool
+	Suspended         bool
 	SessionsCount     int64
 	UploadTokensCount int64
 	LastActivity      time.Time // Last session or upload token usage
+	Quota             quota
 }
 
-func (app *Application) toAccountStats(account *Accounts, requesterAccountID uint) (stats AccountStats, err error) {
-	files, err := app.db.getAllFilesFromAccount(account.ID)
-	if err != nil {
-		return
+func (app *Application) toAccountStats(row accountStatsRow, requesterAccountID uint) (stats AccountStats) {
+	stats = AccountStats{
+		Accounts:          row.Accounts,
+		SpaceUsed:         row.SpaceUsed,
+		FilesUploaded:     row.FilesUploaded,
+		You:               row.ID == requesterAccountID,
+		Suspended:         row.Accounts.Suspended(),
+		SessionsCount:     row.SessionsCount,
+		UploadTokensCount: row.UploadTokensCount,
+		LastActivity:      row.LastActivity.Time,
+		Quota:             app.quotaFor(row.Accounts),
+	}
+
+	if row.InvitedBy == 0 {
+		stats.InvitedBy = "system"
+	} else if row.InviterUsername != "" {
+		stats.InvitedBy = fmt.Sprintf("%s (%d)", row.InviterUsername, row.InvitedBy)
+	} else {
+		stats.InvitedBy = strconv.Itoa(int(row.InvitedBy))
 	}
 
-	stats = AccountStats{
-		Accounts: *account,
-		You:      account.ID == requesterAccountID,
+	if stats.GithubUsername == "" {
+		stats.GithubUsername = "none"
 	}
 
-	stats.SessionsCount, err = app.db.getSessionsCount(account.ID)
-	if err != nil {
-		log.Err(err).Msg("Failed to get session count")
+	return
+}
+
+type AdminUsersApiInput struct {
+	AccountsFilter
+	Skip uint `form:"skip"`
+}
+
+type AdminUsersApiOutput struct {
+	Users []AccountStats
+	Count int64
+}
+
+// Api for listing users on the admin page, returns 20 users at a time
+func (app *Application) adminUsersAPI(c *gin.Context) {
+	var input AdminUsersApiInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
-	stats.UploadTokensCount, err = app.db.getUploadTokensCount(account.ID)
-	if err != nil {
-		log.Err(err).Msg("Failed to get upload token count")
+	if _, ok := accountSorts[input.Sort]; !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if input.Role != "" && !Role(input.Role).Valid() {
+		c.AbortWithError(http.StatusBadRequest, ErrInvalidRole)
+		return
 	}
 
-	stats.LastActivity, err = app.db.lastAccountActivity(account.ID)
+	account, err := app.accountFromContext(c)
 	if err != nil {
-		log.Err(err).Msg("Failed to get last activity")
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
 
-	if account.InvitedBy == 0 {
-		stats.InvitedBy = "system"
-	} else if account.InvitedBy > 0 {
-		invitedBy, err := app.db.getAccountByID(account.InvitedBy)
-		if err == nil && invitedBy.GithubUsername != "" {
-			stats.InvitedBy = fmt.Sprintf("%s (%d)", invitedBy.GithubUsername, invitedBy.ID)
-		} else {
-			stats.InvitedBy = strconv.Itoa(int(account.InvitedBy))
-		}
-	} else {
-		stats.InvitedBy = strconv.Itoa(int(account.InvitedBy))
+	rows, err := app.db.withContext(c).getAccountStats(input.AccountsFilter, input.Skip, 20)
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get account stats")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
 
-	for _, file := range files {
-		stats.SpaceUsed += file.FileSize
-		stats.FilesUploaded++
+	output := AdminUsersApiOutput{Users: make([]AccountStats, 0, len(rows))}
+	for _, row := range rows {
+		output.Users = append(output.Users, app.toAccountStats(row, account.ID))
 	}
 
-	if stats.GithubUsername == "" {
-		stats.GithubUsername = "none"
+	if output.Count, err = app.db.withContext(c).countAccounts(input.AccountsFilter); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to count accounts")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
 
-	return
+	c.JSON(http.StatusOK, output)
 }
 
 func (app *Application) adminPage(c *gin.Context) {
@@ -117,7 +153,7 @@ func (app *Application) adminPage(c *gin.Context) {
 		return
 	}
 
-	if account.AccountType != "ADMIN" {
+	if !account.AccountType.Can(PermViewAdmin) {
 		c.Redirect(http.StatusTemporaryRedirect, "/login")
 		return
 	}
@@ -132,27 +168,20 @@ func (app *Application) adminPage(c *gin.Context) {
 		// For top bar
 		templateInput["LoggedIn"] = true
 		templateInput["AccountID"] = account.ID
-		templateInput["IsAdmin"] = account.AccountType == "ADMIN"
+		templateInput["CanViewAdmin"] = account.AccountType.Can(PermViewAdmin)
+		templateInput["CsrfToken"] = app.csrfToken(c)
 
-		users, err := app.db.getAccounts()
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+		templateInput["Role"] = account.AccountType
+		templateInput["Roles"] = Roles
+		templateInput["MaxUploadSize"] = uint(app.config.MaxUploadSize)
+		templateInput["Retention"] = app.config.Retention
+		templateInput["AuditActions"] = AuditActions
 
-		var stats []AccountStats
-		for _, user := range users {
-			stat, err := app.toAccountStats(&user, account.ID)
-			if err != nil {
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
+		if account.AccountType.Can(PermHandleReports) {
+			if templateInput["OpenReports"], err = app.db.withContext(c).countReports(ReportOpen); err != nil {
+				log.Ctx(c).Err(err).Msg("Failed to count open reports")
 			}
-
-			stats = append(stats, stat)
 		}
-
-		templateInput["Users"] = stats
-		templateInput["MaxUploadSize"] = uint(app.config.MaxUploadSize)
 		templateInput["Version"] = Version
 	}
 
@@ -163,6 +192,32 @@ func (app *Application) adminPage(c *gin.Context) {
 	}
 }
 
+func (app *Application) adminFilesPage(c *gin.Context) {
+	_, account, loggedIn, err := app.validateAuthCookie(c)
+	if errors.Is(err, ErrInvalidAuthCookie) {
+		app.clearAuthCookie(c)
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if !loggedIn || !account.AccountType.Can(PermModerateFiles) {
+		c.Redirect(http.StatusTemporaryRedirect, "/login")
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_files.gohtml", gin.H{
+		"CurrentPage":  "admin",
+		"Branding":     app.config.Branding,
+		"Tagline":      app.config.Tagline,
+		"LoggedIn":     true,
+		"AccountID":    account.ID,
+		"CanViewAdmin": true,
+		"CsrfToken":    app.csrfToken(c),
+		"Role":         account.AccountType,
+	})
+}
+
 func (app *Application) userPage(c *gin.Context) {
 	_, account, loggedIn, err := app.validateAuthCookie(c)
 	if errors.Is(err, ErrInvalidAuthCookie) {
@@ -187,23 +242,26 @@ func (app *Application) userPage(c *gin.Context) {
 		// For top bar
 		templateInput["LoggedIn"] = true
 		templateInput["AccountID"] = account.ID
-		templateInput["IsAdmin"] = account.AccountType == "ADMIN"
+		templateInput["CanViewAdmin"] = account.AccountType.Can(PermViewAdmin)
+		templateInput["CsrfToken"] = app.csrfToken(c)
 
 		templateInput["UnlinkedAccount"] = account.GithubID == 0
 
-		templateInput["InviteCodes"], err = app.db.inviteCodesByAccount(account.ID)
+		templateInput["InviteCodes"], err = app.db.withContext(c).inviteCodesByAccount(account.ID)
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
-		uploadTokens, err := app.db.getUploadTokens(account.ID)
+		uploadTokens, err := app.db.withContext(c).getUploadTokens(account.ID)
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
 		templateInput["UploadTokens"] = uploadTokens
+		templateInput["TrashRetention"] = app.config.TrashRetention
+		templateInput["ExportLinkLifetime"] = exportLinkLifetime
 	}
 
 	if loggedIn {
@@ -279,36 +337,70 @@ func (app *Application) indexFiles(c *gin.Context) {
 	// Looks in database for uploaded file
 	fileName := path.Base(path.Clean(c.Request.URL.Path))
 
-	fileRecord, err := app.db.getFileByName(fileName)
+	fileRecord, err := app.db.withContext(c).getFileByName(fileName)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		c.Redirect(http.StatusTemporaryRedirect, "/")
+		c.Redirect(http.StatusTemporaryRedirect, app.uiURL("/"))
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to get file details")
+		log.Ctx(c).Err(err).Msg("Failed to get file details")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	if !fileRecord.Public {
-		// make sure its the uploader trying to access the file
-		_, account, loggedIn, err := app.validateAuthCookie(c)
-		if err != nil || !loggedIn || account.ID != fileRecord.UploaderID {
-			c.AbortWithStatus(http.StatusForbidden)
-			return
+	// The auth cookie doesn't reach the content host, signed links are used for files needing a login there
+	var viewer Accounts
+	if !fileRecord.Public || fileRecord.ModerationStatus != "" || fileRecord.Uploader.FilesHidden() {
+		if !app.isContentHost(c) || !app.validFileSignature(c, fileName) {
+			_, account, loggedIn, err := app.validateAuthCookie(c)
+			switch status := fileAccessStatus(fileRecord, account, err == nil && loggedIn); status {
+			case http.StatusOK:
+				viewer = account
+			case http.StatusUnavailableForLegalReasons:
+				c.String(status, "This file has been disabled following a report")
+				c.Abort()
+				return
+			default:
+				c.AbortWithStatus(status)
+				return
+			}
 		}
 	}
 
-	if err := app.db.bumpFileViews(fileName, c.ClientIP()); err != nil {
-		log.Err(err).Msg("Failed to bump file views")
+	if app.config.ContentUrl != "" && !app.isContentHost(c) {
+		c.Redirect(http.StatusTemporaryRedirect, app.fileURL(fileRecord, viewer))
+		return
+	}
+
+	// Files are served as is, the report link for people is on the /view page. The header points tools at the form.
+	c.Header("Link", fmt.Sprintf("<%s>; rel=\"report\"", app.uiURL("/report?file="+url.QueryEscape(fileName))))
+
+	if err := app.db.withContext(c).bumpFileViews(fileName, c.ClientIP()); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to bump file views")
 	}
 
 	switch app.config.FileStorageMethod {
 	case fileStorageS3:
+		// The bucket can't send nosniff or the sandbox policy, so risky files are passed through here instead
+		if app.servedAsAttachment(fileRecord.MimeType) {
+			reader, err := app.openFile(c, fileRecord.FileName)
+			if err != nil {
+				log.Ctx(c).Err(err).Msg("Failed to open file from bucket")
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			defer reader.Close()
+
+			app.setFileHeaders(c, fileRecord)
+			c.DataFromReader(http.StatusOK, int64(fileRecord.FileSize), fileRecord.MimeType, reader, nil)
+			return
+		}
+
 		c.Redirect(http.StatusTemporaryRedirect, "https://"+app.config.S3.CdnDomain+"/file/"+app.config.S3.Bucket+path.Clean(c.Request.URL.Path))
 	case fileStorageLocal:
+		app.setFileHeaders(c, fileRecord)
 		c.File(filepath.Join(app.config.DataFolder, path.Clean(c.Request.URL.Path)))
 	default:
-		log.Err(ErrUnknownStorageMethod).Msg("No storage method chosen")
+		log.Ctx(c).Err(ErrUnknownStorageMethod).Msg("No storage method chosen")
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 }
@@ -320,27 +412,30 @@ func (app *Application) newUploadTokenApi(c *gin.Context) {
 		return
 	}
 
-	account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID))
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to fetch user by session token")
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	var uploadToken uuid.UUID
+	var uploadToken string
 
 	nickname := c.PostForm("nickname")
 
-	if uploadToken, err = app.db.createUploadToken(account.ID, nickname); err != nil {
-		log.Err(err).Msg("Failed to create upload token")
+	if uploadToken, err = app.db.withContext(c).createUploadToken(account.ID, nickname); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to create upload token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	c.String(http.StatusOK, uploadToken.String())
+	app.audit(c, account.ID, AuditCreateUploadToken, noTarget, nickname)
+
+	// Only a hash is stored, so this is the only time the token can be shown
+	c.String(http.StatusOK, uploadToken)
 }
 
 func (app *Application) deleteUploadTokenAPI(c *gin.Context) {
@@ -350,34 +445,30 @@ func (app *Application) deleteUploadTokenAPI(c *gin.Context) {
 		return
 	}
 
-	account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID))
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to fetch user by session token")
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	rawUploadToken := c.PostForm("upload_token")
-	if rawUploadToken == "" {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
-	}
-
-	uploadToken, err := uuid.Parse(rawUploadToken)
+	tokenID, err := strconv.ParseUint(c.PostForm("id"), 10, 0)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	if err = app.db.deleteUploadToken(account.ID, uploadToken); err != nil {
-		log.Err(err).Msg("Failed to delete upload token")
+	if err = app.db.withContext(c).deleteUploadToken(account.ID, uint(tokenID)); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to delete upload token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	app.audit(c, account.ID, AuditDeleteUploadToken, uploadTokenTarget(uint(tokenID)), "")
+
 	c.String(http.StatusOK, "Upload token deleted successfully")
 }
 
@@ -388,24 +479,26 @@ func (app *Application) deleteInviteCodeAPI(c *gin.Context) {
 		return
 	}
 
-	account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID))
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to fetch user by session token")
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
 	inviteCode := c.PostForm("invite_code")
 
-	if err = app.db.deleteInviteCode(inviteCode, account.ID); err != nil {
-		log.Err(err).Msg("Failed to delete invite code")
+	if err = app.db.withContext(c).deleteInviteCode(inviteCode, account.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to delete invite code")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	app.audit(c, account.ID, AuditDeleteInviteCode, noTarget, "")
+
 	c.String(http.StatusOK, "Invite code deleted successfully")
 }
 
@@ -416,38 +509,41 @@ func (app *Application) deleteFilesAPI(c *gin.Context) {
 		return
 	}
 
-	account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID))
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to fetch user by session token")
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	if err = app.deleteFilesFromAccount(account.ID); err != nil {
-		log.Err(err).Msg("Failed to delete files from account")
+	if err = app.db.withContext(c).trashFilesFromAccount(account.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to move files from account to trash")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	c.String(http.StatusOK, "Files deleted")
+	app.audit(c, account.ID, AuditTrashAllFiles, accountTarget(account.ID), "")
+
+	c.String(http.StatusOK, "Moved files to trash")
 }
 
-func (app *Application) deleteFilesFromAccount(userID uint) (err error) {
-	files, err := app.db.getAllFilesFromAccount(userID)
+// Permanently deletes all files of the account, including the ones in trash
+func (app *Application) deleteFilesFromAccount(ctx context.Context, userID uint) (err error) {
+	files, err := app.db.withContext(ctx).getAllFilesFromAccountIncludingTrash(userID)
 	if err != nil {
 		return
 	}
 
-	if err = app.db.deleteFilesFromAccount(userID); err != nil {
+	if err = app.db.withContext(ctx).deleteFilesFromAccount(userID); err != nil {
 		return
 	}
 
 	for _, file := range files {
-		if err = app.deleteFile(file.FileName); err != nil {
-			log.Err(err).Msg("Failed to delete file")
+		if err = app.deleteFile(ctx, file.FileName); err != nil {
+			log.Ctx(ctx).Err(err).Str("file_name", file.FileName).Msg("Failed to delete file")
 		}
 	}
 
@@ -457,6 +553,10 @@ func (app *Application) deleteFilesFromAccount(userID uint) (err error) {
 type FileStatsOutput struct {
 	Count     uint `json:"count"`
 	SizeTotal uint `json:"size_total"`
+
+	// Quota limits, 0 means unlimited
+	MaxFiles   uint `json:"max_files"`
+	MaxStorage uint `json:"max_storage"`
 }
 
 func (app *Application) fileStatsAPI(c *gin.Context) {
@@ -466,21 +566,21 @@ func (app *Application) fileStatsAPI(c *gin.Context) {
 		return
 	}
 
-	account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID))
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to fetch user by session token")
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
 	var output FileStatsOutput
 
-	totalFiles, totalStorage, err := app.db.getFileStats(account.ID)
+	totalFiles, totalStorage, err := app.db.withContext(c).getFileStats(account.ID)
 	if err != nil {
-		log.Err(err).Msg("Failed to get file stats")
+		log.Ctx(c).Err(err).Msg("Failed to get file stats")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -488,6 +588,10 @@ func (app *Application) fileStatsAPI(c *gin.Context) {
 	output.Count = totalFiles
 	output.SizeTotal = totalStorage
 
+	q := app.quotaFor(account)
+	output.MaxFiles = q.MaxFiles
+	output.MaxStorage = q.MaxStorage
+
 	c.JSON(http.StatusOK, output)
 }
 
@@ -509,12 +613,12 @@ func (app *Application) filesAPI(c *gin.Context) {
 		return
 	}
 
-	account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID))
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	} else if err != nil {
-		log.Err(err).Msg("Failed to fetch user by session token")
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -539,21 +643,25 @@ func (app *Application) filesAPI(c *gin.Context) {
 	var limit uint = 8
 
 	var output FilesApiOutput
-	output.Files, err = app.db.getFilesPaginatedFromAccount(account.ID, input.Skip, limit, input.Sort, input.Desc)
+	output.Files, err = app.db.withContext(c).getFilesPaginatedFromAccount(account.ID, input.Skip, limit, input.Sort, input.Desc)
 	if err != nil {
-		log.Err(err).Msg("Failed to get files from account")
+		log.Ctx(c).Err(err).Msg("Failed to get files from account")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	count, err := app.db.filesAmountOnAccount(account.ID)
+	count, err := app.db.withContext(c).filesAmountOnAccount(account.ID)
 	if err != nil {
-		log.Err(err).Msg("Failed to get files amount on account")
+		log.Ctx(c).Err(err).Msg("Failed to get files amount on account")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
 	output.Count = count
 
+	for i := range output.Files {
+		output.Files[i].Url = app.fileURL(output.Files[i], account)
+	}
+
 	c.JSON(http.StatusOK, output)
 }
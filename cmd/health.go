@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	readinessCheckTimeout     = 5 * time.Second
+	storageCheckCacheLifetime = 10 * time.Second
+)
+
+// The probe file always has the same name so a check that never finished can't leave a pile of them behind
+const storageProbeFileName = ".readyz-probe"
+
+var (
+	ErrStorageReadMismatch   = errors.New("read back different content than was written")
+	ErrSchedulerNotRunning   = errors.New("job scheduler isn't running")
+	ErrReadinessCheckTimeout = errors.New("check timed out")
+)
+
+type readinessCheck struct {
+	Status   string `json:"status"` // ok or fail
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+type readinessOutput struct {
+	Status string                    `json:"status"`
+	Checks map[string]readinessCheck `json:"checks"`
+}
+
+// The storage check writes to the data folder or bucket, so its result is shared by every probe for a while
+type storageCheckCache struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	result    readinessCheck
+}
+
+// Liveness probe, only tells that the process is serving requests
+func (app *Application) healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readiness probe, answers 503 when any dependency is broken
+func (app *Application) readyzHandler(c *gin.Context) {
+	output := readinessOutput{
+		Status: "ok",
+		Checks: map[string]readinessCheck{
+			"database":  runReadinessCheck(c.Request.Context(), app.checkDatabase),
+			"storage":   app.cachedStorageCheck(c.Request.Context()),
+			"scheduler": runReadinessCheck(c.Request.Context(), app.checkScheduler),
+		},
+	}
+
+	status := http.StatusOK
+	for _, check := range output.Checks {
+		if check.Status != "ok" {
+			output.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(status, output)
+}
+
+// Checks get the timeout through ctx, local disk access can't be cancelled though, so a hanging check is left behind
+// instead of blocking the probe
+func runReadinessCheck(ctx context.Context, check func(ctx context.Context) error) readinessCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() { result <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ErrReadinessCheckTimeout
+	}
+
+	output := readinessCheck{Status: "ok", Duration: time.Since(start).String()}
+	if err != nil {
+		output.Status = "fail"
+		output.Error = err.Error()
+	}
+
+	return output
+}
+
+func (app *Application) checkDatabase(ctx context.Context) error {
+	sqlDB, err := app.db.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+// Concurrent probes wait for the running check instead of starting their own
+func (app *Application) cachedStorageCheck(ctx context.Context) readinessCheck {
+	cache := &app.storageCheck
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if time.Since(cache.checkedAt) < storageCheckCacheLifetime {
+		return cache.result
+	}
+
+	cache.result = runReadinessCheck(ctx, app.checkStorage)
+	cache.checkedAt = time.Now()
+
+	return cache.result
+}
+
+// Writes, reads back and deletes a small probe file in the data folder or bucket
+func (app *Application) checkStorage(ctx context.Context) (err error) {
+	content := []byte(randomString())
+
+	if err = app.storeFile(ctx, content, storageProbeFileName, "text/plain", storageProbeFileName); err != nil {
+		return
+	}
+
+	// Still cleans up when the probe gave up waiting on a slow check
+	defer func() {
+		if deleteErr := app.deleteFile(context.WithoutCancel(ctx), storageProbeFileName); err == nil {
+			err = deleteErr
+		}
+	}()
+
+	stored, err := app.readFile(ctx, storageProbeFileName)
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(stored, content) {
+		return ErrStorageReadMismatch
+	}
+
+	return
+}
+
+// The scheduler is running when every job has its next run planned
+func (app *Application) checkScheduler(context.Context) error {
+	if app.cron == nil {
+		return ErrSchedulerNotRunning
+	}
+
+	jobs := app.cron.Jobs()
+	if len(jobs) == 0 {
+		return ErrSchedulerNotRunning
+	}
+
+	for _, job := range jobs {
+		nextRun, err := job.NextRun()
+		if err != nil {
+			return err
+		}
+
+		if nextRun.IsZero() {
+			return ErrSchedulerNotRunning
+		}
+	}
+
+	return nil
+}
@@ -1,25 +1,92 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"crypto/rand"
 
 	"path/filepath"
+	"time"
 
 	"github.com/gabriel-vasile/mimetype"
-	"github.com/google/uuid"
-	"gorm.io/gorm"
+	"github.com/gin-gonic/gin"
 )
 
-func (app *Application) deleteFile(fileName string) (err error) {
+func (app *Application) deleteFile(ctx context.Context, fileName string) (err error) {
+	ctx, span := startStorageSpan(ctx, "delete", app.config.FileStorageMethod, fileName)
+	defer endSpan(span, &err)
+	defer app.observeStorage("delete", time.Now(), &err)
+
 	switch app.config.FileStorageMethod {
 	case fileStorageLocal:
 		err = os.Remove(filepath.Join(app.config.DataFolder, fileName))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	case fileStorageS3:
+		err = app.deleteFileS3(ctx, fileName)
+	default:
+		err = ErrUnknownStorageMethod
+	}
+
+	return
+}
+
+// Risky types are stored with download headers on S3, the local storage sets them when serving instead
+func (app *Application) storeFile(ctx context.Context, file []byte, fileName string, mimeType string, originalFileName string) (err error) {
+	ctx, span := startStorageSpan(ctx, "store", app.config.FileStorageMethod, fileName)
+	defer endSpan(span, &err)
+	defer app.observeStorage("store", time.Now(), &err)
+
+	switch app.config.FileStorageMethod {
+	case fileStorageS3:
+		var disposition string
+		if app.servedAsAttachment(mimeType) {
+			disposition = attachmentDisposition(originalFileName)
+		}
+
+		err = app.uploadFileS3(ctx, file, fileName, mimeType, disposition)
+	case fileStorageLocal:
+		err = os.WriteFile(filepath.Join(app.config.DataFolder, fileName), file, 0o600)
+	default:
+		err = ErrUnknownStorageMethod
+	}
+
+	return
+}
+
+func (app *Application) readFile(ctx context.Context, fileName string) (file []byte, err error) {
+	ctx, span := startStorageSpan(ctx, "read", app.config.FileStorageMethod, fileName)
+	defer endSpan(span, &err)
+	defer app.observeStorage("read", time.Now(), &err)
+
+	switch app.config.FileStorageMethod {
+	case fileStorageLocal:
+		file, err = os.ReadFile(filepath.Join(app.config.DataFolder, fileName))
+	case fileStorageS3:
+		file, err = app.readFileS3(ctx, fileName)
+	default:
+		err = ErrUnknownStorageMethod
+	}
+
+	return
+}
+
+// Streams the file instead of reading it into memory, the caller has to close it
+func (app *Application) openFile(ctx context.Context, fileName string) (file io.ReadCloser, err error) {
+	ctx, span := startStorageSpan(ctx, "open", app.config.FileStorageMethod, fileName)
+	defer endSpan(span, &err)
+	defer app.observeStorage("open", time.Now(), &err)
+
+	switch app.config.FileStorageMethod {
+	case fileStorageLocal:
+		file, err = os.Open(filepath.Join(app.config.DataFolder, fileName))
 	case fileStorageS3:
-		err = app.deleteFileS3(fileName)
+		file, err = app.openFileS3(ctx, fileName)
 	default:
 		err = ErrUnknownStorageMethod
 	}
@@ -35,13 +102,24 @@ func (app *Application) generateFullFileName(mime *mimetype.MIME) string {
 	return fmt.Sprintf("%s%s", randomString(), mime.Extension())
 }
 
-func (app *Application) isValidUploadToken(uploadToken uuid.UUID) (bool, error) {
-	_, err := app.db.getAccountByUploadToken(uploadToken)
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return false, nil
-	} else if err != nil {
-		return false, err
+// Looks up the account from the session or upload token set by the auth middlewares
+// The result is kept in the context so later handlers don't have to query it again
+func (app *Application) accountFromContext(c *gin.Context) (account Accounts, err error) {
+	if cached, exists := c.Get("account"); exists {
+		return cached.(Accounts), nil
 	}
 
-	return true, nil
+	if sessionToken, exists := c.Get("sessionToken"); exists {
+		account, err = app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
+	} else if uploadToken, exists := c.Get("uploadToken"); exists {
+		account, err = app.db.withContext(c).getAccountByUploadToken(uploadToken.(string))
+	} else {
+		return account, ErrNotAuthenticated
+	}
+
+	if err == nil {
+		c.Set("account", account)
+	}
+
+	return
 }
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type loggingConfig struct {
+	Level     string `toml:"level"`       // trace, debug, info, warn or error, defaults to info
+	Format    string `toml:"format"`      // json or console, defaults to json
+	IpLogging string `toml:"ip_logging"`  // hash, full or omit, defaults to hash
+	IpHashKey string `toml:"ip_hash_key"` // Secret the ip hashes of logs, the audit log and reports are keyed with
+}
+
+const (
+	ipLoggingHash = "hash"
+	ipLoggingFull = "full"
+	ipLoggingOmit = "omit"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Request ids coming from a proxy are kept when they look sane
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Sets up the global logger, called before anything else in the config is looked at
+func (c *loggingConfig) apply() {
+	if c.Level == "" {
+		c.Level = "info"
+	}
+
+	level, err := zerolog.ParseLevel(c.Level)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Unknown log level %q", c.Level)
+	}
+	zerolog.SetGlobalLevel(level)
+
+	switch c.Format {
+	case "", "json":
+		c.Format = "json"
+	case "console":
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.DateTime})
+	default:
+		log.Fatal().Msgf("Unknown log format %q, has to be json or console", c.Format)
+	}
+
+	switch c.IpLogging {
+	case "":
+		c.IpLogging = ipLoggingHash
+	case ipLoggingHash, ipLoggingFull, ipLoggingOmit:
+	default:
+		log.Fatal().Msgf("Unknown ip_logging %q, has to be hash, full or omit", c.IpLogging)
+	}
+
+	if c.IpHashKey == "" {
+		log.Warn().Msg("No ip_hash_key set, ip hashes can't be matched up across restarts")
+		c.IpHashKey = rand.Text()
+	}
+
+	// Code without a request logger in its context logs through the global one
+	zerolog.DefaultContextLogger = &log.Logger
+}
+
+// A plain hash of an ip can be reversed by hashing every address, keying it with a secret prevents that
+func (app *Application) ipHash(ip string) string {
+	mac := hmac.New(sha256.New, []byte(app.config.Logging.IpHashKey))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Gives every request an id that is sent back in X-Request-ID and added to each log line of the request through log.Ctx(c)
+func (app *Application) accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Header(requestIDHeader, requestID)
+
+		loggerContext := log.With().Str("request_id", requestID)
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			loggerContext = loggerContext.Str("trace_id", span.TraceID().String())
+		}
+
+		logger := loggerContext.Logger()
+		c.Request = c.Request.WithContext(logger.WithContext(c.Request.Context()))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		event := logger.Info()
+		if status >= 500 {
+			event = logger.Error()
+		}
+
+		event = event.
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path). // The query is left out since it can hold link signatures
+			Int("status", status).
+			Int("size", c.Writer.Size()).
+			Dur("duration", time.Since(start)).
+			Str("user_agent", c.Request.UserAgent())
+
+		switch app.config.Logging.IpLogging {
+		case ipLoggingHash:
+			event = event.Str("ip_hash", app.ipHash(c.ClientIP()))
+		case ipLoggingFull:
+			event = event.Str("ip", c.ClientIP())
+		}
+
+		if account, exists := c.Get("account"); exists {
+			event = event.Uint("account_id", account.(Accounts).ID)
+		}
+
+		if _, exists := c.Get("uploadToken"); exists {
+			event = event.Str("auth", "upload_token")
+		} else if _, exists := c.Get("account"); exists {
+			event = event.Str("auth", "session")
+		}
+
+		if len(c.Errors) > 0 {
+			event = event.Str("errors", c.Errors.String())
+		}
+
+		event.Msg("Request")
+	}
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type metricsConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"` // Separate address for /metrics e.g ":9100", empty serves it on the main port
+	Token   string `toml:"token"`  // Optional bearer token scrapers have to send
+}
+
+// Metrics are always collected, the config only decides if and where they are exposed
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "hostling_http_requests_total",
+		Help: "HTTP requests by route group, method and status code.",
+	}, []string{"group", "method", "status"})
+
+	httpRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hostling_http_request_duration_seconds",
+		Help:    "HTTP request latency by route group.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"group", "method"})
+
+	uploadsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "hostling_uploads_total",
+		Help: "Stored uploads by mime class.",
+	}, []string{"mime_class"})
+
+	uploadBytesTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "hostling_upload_bytes_total",
+		Help: "Bytes of stored uploads by mime class.",
+	}, []string{"mime_class"})
+
+	storageOperationDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hostling_storage_operation_duration_seconds",
+		Help:    "Latency of storage backend operations.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+
+	storageOperationErrors = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "hostling_storage_operation_errors_total",
+		Help: "Failed storage backend operations.",
+	}, []string{"backend", "operation"})
+
+	dbQueryDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hostling_db_query_duration_seconds",
+		Help:    "Database query timings by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	cleanupJobDuration = promauto.With(metricsRegistry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "hostling_cleanup_job_duration_seconds",
+		Help:    "How long CleanUpJob runs take.",
+		Buckets: []float64{.1, .5, 1, 5, 10, 30, 60, 300},
+	})
+
+	cleanupFilesPurged = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "hostling_cleanup_files_purged_total",
+		Help: "Expired and trashed files purged by CleanUpJob.",
+	})
+
+	rateLimitRejections = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "hostling_ratelimit_rejections_total",
+		Help: "Requests rejected by a rate limiter.",
+	}, []string{"limiter"})
+)
+
+// Gauges that are queried from the database on every scrape
+func (app *Application) registerStateMetrics() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "hostling_active_sessions",
+			Help: "Session tokens that haven't expired.",
+		}, func() float64 {
+			count, err := app.db.countActiveSessions()
+			if err != nil {
+				log.Err(err).Msg("Failed to count active sessions for metrics")
+			}
+
+			return float64(count)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "hostling_stored_bytes",
+			Help:        "Bytes stored in the storage backend, including trashed files.",
+			ConstLabels: prometheus.Labels{"backend": storageBackendLabel(app.config.FileStorageMethod)},
+		}, func() float64 {
+			size, err := app.db.totalStoredBytes()
+			if err != nil {
+				log.Err(err).Msg("Failed to sum stored bytes for metrics")
+			}
+
+			return float64(size)
+		}),
+	)
+}
+
+func storageBackendLabel(method fileStorageMethod) string {
+	return strings.ToLower(string(method))
+}
+
+// Records how long a storage operation took and if it failed, meant to be deferred
+func (app *Application) observeStorage(operation string, start time.Time, err *error) {
+	backend := storageBackendLabel(app.config.FileStorageMethod)
+	storageOperationDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+
+	if *err != nil {
+		storageOperationErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// Top level part of the mime type e.g image, with anything unusual grouped as other
+func mimeClass(mimeType string) string {
+	class, _, _ := strings.Cut(baseMimeType(mimeType), "/")
+	switch class {
+	case "image", "video", "audio", "text", "application", "font", "model":
+		return class
+	default:
+		return "other"
+	}
+}
+
+func recordUpload(mimeType string, size int) {
+	class := mimeClass(mimeType)
+	uploadsTotal.WithLabelValues(class).Inc()
+	uploadBytesTotal.WithLabelValues(class).Add(float64(size))
+}
+
+// Groups routes so the metrics don't grow with every file name
+func routeGroup(c *gin.Context) string {
+	route := c.FullPath()
+
+	switch {
+	case route == "":
+		return "file" // Uploads are served by NoRoute
+	case strings.HasPrefix(route, "/api/admin"):
+		return "admin_api"
+	case strings.HasPrefix(route, "/api/account"):
+		return "account_api"
+	case strings.HasPrefix(route, "/api/file"):
+		return "file_api"
+	case strings.HasPrefix(route, "/api/auth"):
+		return "auth_api"
+	case strings.HasPrefix(route, "/api"):
+		return "api"
+	case strings.HasPrefix(route, "/public/"):
+		return "static"
+	case route == "/metrics":
+		return "metrics"
+	case route == "/healthz" || route == "/readyz":
+		return "health"
+	default:
+		return "page"
+	}
+}
+
+func (app *Application) metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		group := routeGroup(c)
+		httpRequestsTotal.WithLabelValues(group, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(group, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (app *Application) metricsHandler() http.Handler {
+	handler := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	if app.config.Metrics.Token == "" {
+		return handler
+	}
+
+	expected := []byte("Bearer " + app.config.Metrics.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Serves /metrics on its own address when metrics.listen is set
+func (app *Application) metricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.metricsHandler())
+
+	log.Info().Msgf("Serving metrics at %s/metrics", app.config.Metrics.Listen)
+	return &http.Server{
+		Addr:    app.config.Metrics.Listen,
+		Handler: mux,
+	}
+}
+
+const dbMetricsStartKey = "hostling:metrics_start"
+
+// Times every query through gorm callbacks
+func registerDBMetrics(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(dbMetricsStartKey, time.Now())
+	}
+
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if start, ok := tx.InstanceGet(dbMetricsStartKey); ok {
+				dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start.(time.Time)).Seconds())
+			}
+		}
+	}
+
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register("metrics:before_create", before),
+		callbacks.Create().After("*").Register("metrics:after_create", after("create")),
+		callbacks.Query().Before("*").Register("metrics:before_query", before),
+		callbacks.Query().After("*").Register("metrics:after_query", after("query")),
+		callbacks.Update().Before("*").Register("metrics:before_update", before),
+		callbacks.Update().After("*").Register("metrics:after_update", after("update")),
+		callbacks.Delete().Before("*").Register("metrics:before_delete", before),
+		callbacks.Delete().After("*").Register("metrics:after_delete", after("delete")),
+		callbacks.Row().Before("*").Register("metrics:before_row", before),
+		callbacks.Row().After("*").Register("metrics:after_row", after("row")),
+		callbacks.Raw().Before("*").Register("metrics:before_raw", before),
+		callbacks.Raw().After("*").Register("metrics:after_raw", after("raw")),
+	)
+}
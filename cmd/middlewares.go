@@ -1,22 +1,29 @@
 package cmd
 
 import (
+	"crypto/subtle"
 	"errors"
 	"net/http"
 
 	"github.com/didip/tollbooth/v8"
+	"github.com/didip/tollbooth/v8/limiter"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
 func (app *Application) ratelimitMiddleware() gin.HandlerFunc {
+	return app.limitMiddleware("global", app.RateLimiter)
+}
+
+// name labels the limiter in the rejection metrics
+func (app *Application) limitMiddleware(name string, rateLimiter *limiter.Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		httpError := tollbooth.LimitByRequest(app.RateLimiter, c.Writer, c.Request)
+		httpError := tollbooth.LimitByRequest(rateLimiter, c.Writer, c.Request)
 		if httpError != nil {
-			c.Data(httpError.StatusCode, app.RateLimiter.GetMessageContentType(), []byte(httpError.Message))
+			rateLimitRejections.WithLabelValues(name).Inc()
+			c.Data(httpError.StatusCode, rateLimiter.GetMessageContentType(), []byte(httpError.Message))
 			c.Abort()
 		} else {
 			c.Next()
@@ -51,21 +58,23 @@ type SessionTokenVerification struct {
 	SessionToken string `form:"token"`
 }
 
-func (app *Application) parseSessionTokenFromForm(c *gin.Context) (sessionToken uuid.UUID, err error) {
+func (app *Application) parseSessionTokenFromForm(c *gin.Context) (sessionToken string, err error) {
 	var form SessionTokenVerification
 	if err = c.ShouldBindWith(&form, binding.FormPost); err != nil {
 		return
 	}
 
-	sessionToken, err = uuid.Parse(form.SessionToken)
+	sessionToken, err = parseToken(form.SessionToken)
 
 	return
 }
 
-func (app *Application) parseSessionTokenFromCookieOrForm(c *gin.Context) (sessionToken uuid.UUID, err error) {
+func (app *Application) parseSessionTokenFromCookieOrForm(c *gin.Context) (sessionToken string, fromCookie bool, err error) {
 	sessionToken, err = app.parseAuthCookie(c)
 	if err != nil {
 		sessionToken, err = app.parseSessionTokenFromForm(c)
+	} else {
+		fromCookie = true
 	}
 
 	return
@@ -77,10 +86,11 @@ func (app *Application) verifySessionAuthentication() gin.HandlerFunc {
 		sessionToken, err := app.parseSessionTokenFromForm(c)
 		if err != nil {
 			// Fallback to checking cookie
-			log.Debug().Msg("Validating cookie")
+			log.Ctx(c).Debug().Msg("Validating cookie")
 			var loggedIn bool
 			sessionToken, _, loggedIn, _ = app.validateAuthCookie(c)
 			if loggedIn {
+				c.Set("cookieAuthenticated", true)
 			} else {
 				c.AbortWithError(http.StatusUnauthorized, err)
 				return
@@ -92,32 +102,6 @@ func (app *Application) verifySessionAuthentication() gin.HandlerFunc {
 	}
 }
 
-// Makes sure the authenticated user is admin
-func (app *Application) isAdmin() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Verify the field exists
-		sessionToken, exists := c.Get("sessionToken")
-		if !exists {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
-		if account, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID)); errors.Is(err, gorm.ErrRecordNotFound) {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		} else if err != nil {
-			log.Err(err).Msg("Failed to find user by session token")
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		} else if account.AccountType != "ADMIN" {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
-		c.Next()
-	}
-}
-
 // Makes sure the user token provided is valid
 func (app *Application) isSessionAuthenticated() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -128,15 +112,17 @@ func (app *Application) isSessionAuthenticated() gin.HandlerFunc {
 			return
 		}
 
-		if _, err := app.db.getAccountBySessionToken(sessionToken.(uuid.UUID)); errors.Is(err, gorm.ErrRecordNotFound) {
+		account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		} else if err != nil {
-			log.Err(err).Msg("Failed to find user by session token")
+			log.Ctx(c).Err(err).Msg("Failed to find user by session token")
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
+		c.Set("account", account)
 		c.Next()
 	}
 }
@@ -147,32 +133,77 @@ func (app *Application) hasUploadOrSessionTokenMiddleware() gin.HandlerFunc {
 		rawUploadToken, uploadTokenExists := c.GetPostForm("upload_token")
 
 		if uploadTokenExists && rawUploadToken != "" {
-			var uploadToken uuid.UUID
+			var uploadToken string
 			var err error
-			if uploadToken, err = uuid.Parse(rawUploadToken); err != nil {
+			if uploadToken, err = parseToken(rawUploadToken); err != nil {
 				c.AbortWithError(http.StatusUnauthorized, err)
 				return
 			}
 
-			valid, err := app.isValidUploadToken(uploadToken)
-			if err != nil { // Could be a database error
-				log.Err(err).Msg("Failed to check if upload token is valid")
+			account, err := app.db.withContext(c).getAccountByUploadToken(uploadToken)
+			if errors.Is(err, gorm.ErrRecordNotFound) { // Wrong or expired token given
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				log.Ctx(c).Err(err).Msg("Failed to check if upload token is valid")
 				c.AbortWithStatus(http.StatusInternalServerError)
 				return
-			} else if !valid { // Wrong or expired token given
-				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+
+			// Tokens are kept during a suspension so they work again once it's lifted
+			if account.Suspended() {
+				c.String(http.StatusForbidden, "Account is suspended")
+				c.Abort()
 				return
 			}
 
 			c.Set("uploadToken", uploadToken)
+			c.Set("account", account)
 		} else {
-			sessionToken, err := app.parseSessionTokenFromCookieOrForm(c)
+			sessionToken, fromCookie, err := app.parseSessionTokenFromCookieOrForm(c)
 			if err != nil {
 				c.AbortWithError(http.StatusUnauthorized, err)
 				return
 			}
 
 			c.Set("sessionToken", sessionToken)
+			c.Set("cookieAuthenticated", fromCookie)
+		}
+
+		c.Next()
+	}
+}
+
+// Makes sure cookie authenticated requests that change state carry the csrf token, token authenticated scripts are exempt
+func (app *Application) csrfMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if !c.GetBool("cookieAuthenticated") {
+			c.Next()
+			return
+		}
+
+		cookieToken, err := c.Cookie(CSRF_COOKIE)
+		if err != nil || cookieToken == "" {
+			c.String(http.StatusForbidden, "Missing CSRF token")
+			c.Abort()
+			return
+		}
+
+		token := c.GetHeader("X-CSRF-Token")
+		if token == "" {
+			token = c.PostForm("csrf_token")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cookieToken)) != 1 {
+			c.String(http.StatusForbidden, "Invalid CSRF token")
+			c.Abort()
+			return
 		}
 
 		c.Next()
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Entries are mime types like "text/html", wildcards like "image/*" or extensions like ".exe"
+type mimePolicyConfig struct {
+	Allow      []string `toml:"allow"`      // Only these can be uploaded, empty allows everything that isn't denied
+	Deny       []string `toml:"deny"`       // These can never be uploaded
+	Attachment []string `toml:"attachment"` // Served as downloads in a sandbox, defaults to defaultAttachmentTypes
+}
+
+// Types browsers would run scripts from if they were served inline from our domain
+var defaultAttachmentTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"image/svg+xml",
+	"text/xml",
+	"application/xml",
+	"text/javascript",
+	"application/javascript",
+	"application/x-shockwave-flash",
+}
+
+// Applied to risky files, scripts can't run even if the browser ends up rendering the file
+const sandboxCSP = "default-src 'none'; style-src 'unsafe-inline'; sandbox"
+
+var ErrMimeTypeNotAllowed = errors.New("file type is not allowed")
+
+// Drops parameters like "; charset=utf-8"
+func baseMimeType(mimeType string) string {
+	base, _, _ := strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(base))
+}
+
+func mimeRuleMatches(rule string, mimeType string, extensions []string) bool {
+	rule = strings.ToLower(strings.TrimSpace(rule))
+
+	if strings.HasPrefix(rule, ".") {
+		return slices.Contains(extensions, rule)
+	}
+
+	if prefix, ok := strings.CutSuffix(rule, "/*"); ok {
+		return strings.HasPrefix(mimeType, prefix+"/")
+	}
+
+	return rule == mimeType
+}
+
+func mimeRulesMatch(rules []string, mimeType string, extensions []string) bool {
+	for _, rule := range rules {
+		if mimeRuleMatches(rule, mimeType, extensions) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks the detected type and both the detected and original extension against the global and role policy.
+// Denies always win, a role allowlist replaces the global one.
+func (app *Application) checkMimePolicy(account Accounts, detectedType string, detectedExtension string, originalFileName string) error {
+	mimeType := baseMimeType(detectedType)
+
+	extensions := []string{strings.ToLower(detectedExtension)}
+	if ext := strings.ToLower(filepath.Ext(originalFileName)); ext != "" {
+		extensions = append(extensions, ext)
+	}
+
+	role := app.config.Roles[account.AccountType]
+
+	if mimeRulesMatch(app.config.MimePolicy.Deny, mimeType, extensions) || mimeRulesMatch(role.DeniedMimeTypes, mimeType, extensions) {
+		return fmt.Errorf("%w: %s", ErrMimeTypeNotAllowed, mimeType)
+	}
+
+	allow := app.config.MimePolicy.Allow
+	if len(role.AllowedMimeTypes) > 0 {
+		allow = role.AllowedMimeTypes
+	}
+
+	if len(allow) > 0 && !mimeRulesMatch(allow, mimeType, extensions) {
+		return fmt.Errorf("%w: %s", ErrMimeTypeNotAllowed, mimeType)
+	}
+
+	return nil
+}
+
+// Risky files are forced to download instead of being rendered inline
+func (app *Application) servedAsAttachment(mimeType string) bool {
+	return mimeRulesMatch(app.config.MimePolicy.Attachment, baseMimeType(mimeType), nil)
+}
+
+func attachmentDisposition(fileName string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": fileName})
+}
+
+// Sets the headers for serving an uploaded file. With S3 only risky files go through here, the rest are
+// served by the CDN without nosniff.
+func (app *Application) setFileHeaders(c *gin.Context, file Files) {
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	if app.servedAsAttachment(file.MimeType) {
+		c.Header("Content-Disposition", attachmentDisposition(file.OriginalFileName))
+		c.Header("Content-Security-Policy", sandboxCSP)
+	}
+}
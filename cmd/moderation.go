@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Stored in the moderation_status column of files, empty means the file isn't moderated
+const (
+	ModerationHidden      = "HIDDEN"      // Only the uploader and moderators can view the file
+	ModerationQuarantined = "QUARANTINED" // Only moderators can view the file
+	ModerationDisabled    = "DISABLED"    // Taken down after a report, served as 451 to everyone but moderators
+)
+
+// Decides who can view a moderated file, applies on top of the public/private status
+func canViewModeratedFile(file Files, account Accounts, loggedIn bool) bool {
+	switch file.ModerationStatus {
+	case "":
+		return true
+	case ModerationHidden:
+		return loggedIn && (account.ID == file.UploaderID || account.AccountType.Can(PermModerateFiles))
+	default:
+		return loggedIn && account.AccountType.Can(PermModerateFiles)
+	}
+}
+
+// Decides who can view a file, returns http.StatusOK when the viewer is allowed to
+func fileAccessStatus(file Files, account Accounts, loggedIn bool) int {
+	if !file.Public || file.ModerationStatus != "" {
+		if file.ModerationStatus == ModerationDisabled && !canViewModeratedFile(file, account, loggedIn) {
+			return http.StatusUnavailableForLegalReasons
+		}
+
+		// make sure its the uploader or a moderator trying to access the file
+		if !loggedIn {
+			return http.StatusForbidden
+		}
+
+		// Private files stay private to their uploader, moderators only get to see the ones they have acted on
+		if !file.Public && account.ID != file.UploaderID && (file.ModerationStatus == "" || !account.AccountType.Can(PermModerateFiles)) {
+			return http.StatusForbidden
+		}
+
+		if !canViewModeratedFile(file, account, loggedIn) {
+			return http.StatusForbidden
+		}
+	}
+
+	// Files of suspended accounts can be hidden from everyone but moderators
+	if file.Uploader.FilesHidden() && (!loggedIn || !account.AccountType.Can(PermModerateFiles)) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusOK
+}
+
+// Byte size that can be given in human readable form, e.g "10 MB"
+type byteSize uint
+
+func (b *byteSize) UnmarshalParam(param string) error {
+	bytes, err := humanize.ParseBytes(param)
+	if err != nil {
+		return err
+	}
+
+	*b = byteSize(bytes)
+	return nil
+}
+
+// Filters for listing files across all accounts
+type FileFilter struct {
+	UploaderID uint `form:"uploader_id"`
+	FileConditions
+}
+
+// Filters on the files themselves, users get these for picking their own files
+type FileConditions struct {
+	MimeType       string    `form:"mime_type"` // Exact type or a wildcard like image/*
+	MinSize        byteSize  `form:"min_size"`
+	MaxSize        byteSize  `form:"max_size"`
+	UploadedAfter  time.Time `form:"uploaded_after" time_format:"2006-01-02"`
+	UploadedBefore time.Time `form:"uploaded_before" time_format:"2006-01-02"` // Inclusive
+	Visibility     string    `form:"visibility"`                               // public, private, hidden, quarantined or disabled
+	MinViews       *uint     `form:"min_views"`
+	MaxViews       *uint     `form:"max_views"`
+}
+
+// A min_views of 0 matches every file, so it doesn't count
+func (f FileConditions) narrows() bool {
+	return f.MimeType != "" || f.MinSize > 0 || f.MaxSize > 0 ||
+		!f.UploadedAfter.IsZero() || !f.UploadedBefore.IsZero() || f.Visibility != "" ||
+		f.MinViews != nil && *f.MinViews > 0 || f.MaxViews != nil
+}
+
+var fileVisibilities = []string{"", "public", "private", "hidden", "quarantined", "disabled"}
+
+// Columns the admin files api can sort by
+var adminFileSorts = map[string]string{
+	"created_at": "files.created_at",
+	"file_size":  "files.file_size",
+	"views":      "views",
+}
+
+type AdminFilesApiInput struct {
+	FileFilter
+	Sort string `form:"sort,default=created_at"`
+	Desc bool   `form:"desc"`
+	Skip uint   `form:"skip"`
+}
+
+type AdminFilesApiOutput struct {
+	Files []AdminFile
+	Count int64
+}
+
+// Api for browsing files across all accounts, returns 25 files at a time
+func (app *Application) adminFilesAPI(c *gin.Context) {
+	var input AdminFilesApiInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := adminFileSorts[input.Sort]; !ok || !slices.Contains(fileVisibilities, input.Visibility) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var (
+		output AdminFilesApiOutput
+		err    error
+	)
+
+	if output.Files, err = app.db.withContext(c).getFilesFiltered(input.FileFilter, input.Sort, input.Desc, input.Skip, 25); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get files")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if output.Count, err = app.db.withContext(c).countFilesFiltered(input.FileFilter); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to count files")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// Signed links skip the access check, so only sign the files the moderator could open anyway
+	for i, f := range output.Files {
+		file := Files{FileName: f.FileName, Public: f.Public, ModerationStatus: f.ModerationStatus, UploaderID: f.UploaderID}
+		if fileAccessStatus(file, actor, true) == http.StatusOK {
+			output.Files[i].Url = app.signedFileURL(f.FileName)
+		} else {
+			output.Files[i].Url = app.fileURL(file, Accounts{})
+		}
+	}
+
+	c.JSON(http.StatusOK, output)
+}
+
+// Every moderation action needs a reason, it ends up in the audit log
+type adminFileActionInput struct {
+	FileName string `form:"file_name"`
+	Reason   string `form:"reason"`
+}
+
+var ErrReasonRequired = errors.New("reason is required")
+
+// Binds the input and looks up the file and the moderator doing the action, base is the common part of input
+func (app *Application) bindAdminFileAction(c *gin.Context, input any, base *adminFileActionInput) (file Files, actor Accounts, ok bool) {
+	if err := c.MustBindWith(input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if base.FileName == "" {
+		c.String(http.StatusBadRequest, "File name is required")
+		c.Abort()
+		return
+	}
+
+	if base.Reason == "" {
+		c.AbortWithError(http.StatusBadRequest, ErrReasonRequired)
+		return
+	}
+
+	file, err := app.db.withContext(c).getFileByName(base.FileName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "File not found")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find file")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if actor, err = app.accountFromContext(c); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	return file, actor, true
+}
+
+// Admin api for permanently deleting a single file
+func (app *Application) adminDeleteFile(c *gin.Context) {
+	var input adminFileActionInput
+	file, actor, ok := app.bindAdminFileAction(c, &input, &input)
+	if !ok {
+		return
+	}
+
+	// purgeFiles logs what went wrong, nothing being purged means the file is still there
+	if purged := app.purgeFiles(c, []Files{file}); len(purged) == 0 {
+		c.String(http.StatusInternalServerError, "Failed to delete file")
+		return
+	}
+
+	app.audit(c, actor.ID, AuditAdminDeleteFile, fileTarget(file.FileName), input.Reason)
+
+	c.String(http.StatusOK, "File deleted")
+}
+
+func (app *Application) setModerationStatus(c *gin.Context, status string, action AuditAction) {
+	var input adminFileActionInput
+	file, actor, ok := app.bindAdminFileAction(c, &input, &input)
+	if !ok {
+		return
+	}
+
+	if err := app.db.withContext(c).setFileModerationStatus(file.ID, status); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to set file moderation status")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, action, fileTarget(file.FileName), input.Reason)
+
+	c.String(http.StatusOK, "File updated")
+}
+
+// Admin api for hiding a file from everyone but the uploader
+func (app *Application) adminHideFile(c *gin.Context) {
+	app.setModerationStatus(c, ModerationHidden, AuditHideFile)
+}
+
+// Admin api for hiding a file from everyone including the uploader
+func (app *Application) adminQuarantineFile(c *gin.Context) {
+	app.setModerationStatus(c, ModerationQuarantined, AuditQuarantineFile)
+}
+
+// Admin api for taking a file down, viewers get a 451
+func (app *Application) adminDisableFile(c *gin.Context) {
+	app.setModerationStatus(c, ModerationDisabled, AuditDisableFile)
+}
+
+// Admin api for undoing a hide, quarantine or disable
+func (app *Application) adminClearFileModeration(c *gin.Context) {
+	app.setModerationStatus(c, "", AuditClearFileModeration)
+}
+
+type adminSetFileExpiryInput struct {
+	adminFileActionInput
+	ExpiryDate string `form:"expiry_date"` // YYYY-MM-DD, empty removes the expiry
+}
+
+// Admin api for changing when a file gets deleted
+func (app *Application) adminSetFileExpiry(c *gin.Context) {
+	var input adminSetFileExpiryInput
+	file, actor, ok := app.bindAdminFileAction(c, &input, &input.adminFileActionInput)
+	if !ok {
+		return
+	}
+
+	var expiryDate time.Time
+	if input.ExpiryDate != "" {
+		var err error
+		if expiryDate, err = time.Parse("2006-01-02", input.ExpiryDate); err != nil {
+			c.String(http.StatusBadRequest, "Invalid expiry date")
+			return
+		}
+	}
+
+	if err := app.db.withContext(c).setFileExpiry(file.ID, expiryDate); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to set file expiry")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	details := input.Reason
+	if !expiryDate.IsZero() {
+		details = fmt.Sprintf("%s (expires %s)", input.Reason, input.ExpiryDate)
+	}
+
+	app.audit(c, actor.ID, AuditSetFileExpiry, fileTarget(file.FileName), details)
+
+	c.String(http.StatusOK, "File expiry updated")
+}
@@ -0,0 +1,67 @@
+import { csrfHeaders, formatTimeDate, humanizeBytes, relativeTime } from './utils.js';
+
+// Exports are built by a background job, so the status is checked again until it's done
+const pollInterval = 5000;
+let pollTimer;
+
+async function loadExport() {
+    clearTimeout(pollTimer);
+
+    const status = document.getElementById('account-export-status');
+    const download = document.getElementById('account-export-download');
+    const request = document.getElementById('account-export-request');
+
+    const response = await fetch('/api/account/export', {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        status.textContent = 'Failed to load export status';
+        return;
+    }
+
+    const accountExport = await response.json();
+    download.hidden = true;
+    request.disabled = false;
+
+    if (!accountExport) {
+        status.textContent = '';
+        return;
+    }
+
+    switch (accountExport.Status) {
+        case 'PENDING':
+            status.textContent = `Preparing your export, requested ${relativeTime(accountExport.CreatedAt)}`;
+            request.disabled = true;
+            pollTimer = setTimeout(loadExport, pollInterval);
+            break;
+        case 'READY':
+            status.textContent = `Export of ${humanizeBytes(accountExport.FileSize)} is ready, the link expires ${relativeTime(accountExport.ExpiresAt)}`;
+            status.title = formatTimeDate(accountExport.ExpiresAt);
+            download.href = `/api/account/download_export?id=${accountExport.ID}`;
+            download.hidden = false;
+            break;
+        case 'FAILED':
+            status.textContent = 'The export failed, try requesting it again';
+            break;
+    }
+}
+
+async function requestExport() {
+    const response = await fetch('/api/account/request_export', {
+        method: 'POST',
+        headers: csrfHeaders(),
+    });
+
+    if (!response.ok) {
+        alert(await response.text() || 'Failed to request export');
+        return;
+    }
+
+    loadExport();
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    document.getElementById('account-export-request').addEventListener('click', requestExport);
+    loadExport();
+});
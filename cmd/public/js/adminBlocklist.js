@@ -0,0 +1,105 @@
+import { csrfHeaders, formatTimeDate, relativeTime } from './utils.js';
+
+const hashesPerPage = 50;
+let currentSkip = 0;
+
+async function postForm(url, formData) {
+    const response = await fetch(url, {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: formData
+    });
+
+    const text = await response.text();
+    if (!response.ok) {
+        alert(text || 'Request failed');
+        return false;
+    }
+
+    if (text) alert(text);
+    return true;
+}
+
+async function unblockHash(hash) {
+    if (!confirm(`Are you sure you want to unblock ${hash}? Files with this hash can be uploaded again.`)) return;
+
+    const formData = new FormData();
+    formData.append('sha256', hash);
+
+    if (await postForm('/api/admin/unblock_hash', formData)) {
+        loadHashes(currentSkip);
+    }
+}
+
+function renderHash(template, entry) {
+    const row = template.content.cloneNode(true);
+
+    row.querySelector('.hash').textContent = entry.Sha256;
+    row.querySelector('.reason').textContent = entry.Reason;
+
+    const createdAt = row.querySelector('.created-at');
+    createdAt.textContent = relativeTime(entry.CreatedAt);
+    createdAt.title = `${formatTimeDate(entry.CreatedAt)} by user ${entry.AddedByID}`;
+
+    row.querySelector('.unblock-button').addEventListener('click', () => unblockHash(entry.Sha256));
+
+    return row;
+}
+
+async function loadHashes(skip = 0) {
+    const entries = document.getElementById('blocklist-entries');
+
+    const params = new URLSearchParams(new FormData(document.getElementById('blocklist-filter')));
+    params.set('skip', skip);
+
+    const response = await fetch(`/api/admin/blocked_hashes?${params}`, {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        entries.textContent = 'Failed to load blocked hashes';
+        return;
+    }
+
+    const data = await response.json();
+    currentSkip = skip;
+
+    const template = document.getElementById('blocklist-entry-template');
+    entries.replaceChildren(...(data.Hashes || []).map(entry => renderHash(template, entry)));
+
+    document.getElementById('blocklist-count').textContent = data.Count === 1 ? '1 hash' : `${data.Count} hashes`;
+
+    const totalPages = Math.max(1, Math.ceil(data.Count / hashesPerPage));
+    const page = Math.floor(currentSkip / hashesPerPage) + 1;
+    document.getElementById('blocklist-page-info').textContent = `Page ${page} of ${totalPages}`;
+    document.getElementById('blocklist-prev').disabled = currentSkip === 0;
+    document.getElementById('blocklist-next').disabled = currentSkip + hashesPerPage >= data.Count;
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    for (const [id, url] of [['block-hash-form', '/api/admin/block_hash'], ['import-hashes-form', '/api/admin/import_blocked_hashes']]) {
+        const form = document.getElementById(id);
+        form.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            if (await postForm(url, new FormData(form))) {
+                form.reset();
+                loadHashes(0);
+            }
+        });
+    }
+
+    document.getElementById('blocklist-filter').addEventListener('submit', (e) => {
+        e.preventDefault();
+        loadHashes(0);
+    });
+
+    document.getElementById('blocklist-prev').addEventListener('click', () => {
+        loadHashes(Math.max(0, currentSkip - hashesPerPage));
+    });
+
+    document.getElementById('blocklist-next').addEventListener('click', () => {
+        loadHashes(currentSkip + hashesPerPage);
+    });
+
+    loadHashes(0);
+});
@@ -0,0 +1,150 @@
+import { csrfHeaders, formatTimeDate, humanizeBytes, relativeTime } from './utils.js';
+
+const filesPerPage = 25;
+let currentSkip = 0;
+
+function filterParams() {
+    const form = document.getElementById('file-filter');
+    const params = new URLSearchParams();
+
+    for (const [key, value] of new FormData(form)) {
+        if (value !== '') {
+            params.append(key, value);
+        }
+    }
+
+    return params;
+}
+
+function fileStatus(file) {
+    if (file.ModerationStatus === 'HIDDEN') return 'Hidden';
+    if (file.ModerationStatus === 'QUARANTINED') return 'Quarantined';
+    if (file.ModerationStatus === 'DISABLED') return 'Disabled';
+    return file.Public ? 'Public' : 'Private';
+}
+
+async function fileAction(action, fileName) {
+    const formData = new FormData();
+    formData.append('file_name', fileName);
+
+    if (action === 'set_file_expiry') {
+        const expiryDate = prompt('New expiry date (YYYY-MM-DD), leave empty to never expire');
+        if (expiryDate === null) return;
+        formData.append('expiry_date', expiryDate);
+    } else if (action === 'delete_file' && !confirm(`Are you sure you want to permanently delete "${fileName}"?`)) {
+        return;
+    }
+
+    const reason = prompt('Reason, this is recorded in the audit log');
+    if (!reason) return;
+    formData.append('reason', reason);
+
+    const response = await fetch(`/api/admin/${action}`, {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: formData
+    });
+
+    if (!response.ok) {
+        alert(await response.text() || 'Action failed');
+        return;
+    }
+
+    loadFiles(currentSkip);
+}
+
+function renderFile(template, file) {
+    const row = template.content.cloneNode(true);
+
+    const link = row.querySelector('.file-link');
+    link.href = file.Url;
+    link.textContent = file.OriginalFileName || file.FileName;
+    link.title = file.FileName;
+
+    row.querySelector('.uploader').textContent = file.UploaderUsername ? `${file.UploaderUsername} (${file.UploaderID})` : `User ${file.UploaderID}`;
+    row.querySelector('.mime-type').textContent = file.MimeType;
+    row.querySelector('.file-size').textContent = humanizeBytes(file.FileSize);
+    row.querySelector('.views').textContent = file.Views;
+
+    const createdAt = row.querySelector('.created-at');
+    createdAt.textContent = relativeTime(file.CreatedAt);
+    createdAt.title = formatTimeDate(file.CreatedAt);
+
+    const expiryDate = row.querySelector('.expiry-date');
+    if (new Date(file.ExpiryDate).getFullYear() > 1) {
+        expiryDate.textContent = relativeTime(file.ExpiryDate);
+        expiryDate.title = formatTimeDate(file.ExpiryDate);
+    } else {
+        expiryDate.textContent = 'Never';
+    }
+
+    const status = row.querySelector('.status');
+    status.textContent = fileStatus(file);
+    if (file.ScanStatus === 'INFECTED') {
+        status.textContent += ` (malware: ${file.ScanSignature})`;
+    } else if (file.ScanStatus) {
+        status.title = `Scan: ${file.ScanStatus.toLowerCase()}`;
+    }
+
+    for (const button of row.querySelectorAll('button[data-action]')) {
+        button.addEventListener('click', () => fileAction(button.dataset.action, file.FileName));
+    }
+
+    return row;
+}
+
+async function loadFiles(skip = 0) {
+    const entries = document.getElementById('file-browser-entries');
+
+    const params = filterParams();
+    params.set('skip', skip);
+
+    const response = await fetch(`/api/admin/files?${params}`, {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        entries.textContent = 'Failed to load files';
+        return;
+    }
+
+    const data = await response.json();
+    currentSkip = skip;
+
+    const template = document.getElementById('file-browser-entry-template');
+    entries.replaceChildren(...(data.Files || []).map(file => renderFile(template, file)));
+
+    document.getElementById('file-browser-count').textContent = data.Count === 1 ? '1 file' : `${data.Count} files`;
+
+    const totalPages = Math.max(1, Math.ceil(data.Count / filesPerPage));
+    const page = Math.floor(currentSkip / filesPerPage) + 1;
+    document.getElementById('file-browser-page-info').textContent = `Page ${page} of ${totalPages}`;
+    document.getElementById('file-browser-prev').disabled = currentSkip === 0;
+    document.getElementById('file-browser-next').disabled = currentSkip + filesPerPage >= data.Count;
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    const form = document.getElementById('file-filter');
+
+    // Lets other pages link to a prefiltered view, e.g /admin/files?uploader_id=1
+    for (const [key, value] of new URLSearchParams(window.location.search)) {
+        if (form.elements[key]) {
+            form.elements[key].value = value;
+        }
+    }
+
+    form.addEventListener('submit', (e) => {
+        e.preventDefault();
+        loadFiles(0);
+    });
+
+    document.getElementById('file-browser-prev').addEventListener('click', () => {
+        loadFiles(Math.max(0, currentSkip - filesPerPage));
+    });
+
+    document.getElementById('file-browser-next').addEventListener('click', () => {
+        loadFiles(currentSkip + filesPerPage);
+    });
+
+    loadFiles(0);
+});
@@ -0,0 +1,114 @@
+import { csrfHeaders, formatTimeDate, relativeTime } from './utils.js';
+
+const reportsPerPage = 25;
+let currentSkip = 0;
+
+async function resolveReport(action, report) {
+    if (action === 'delete_file' && !confirm(`Are you sure you want to permanently delete "${report.FileName}"?`)) {
+        return;
+    } else if (action === 'suspend_uploader' && !confirm(`Are you sure you want to suspend user ${report.UploaderID}? Their files will be hidden.`)) {
+        return;
+    }
+
+    const note = prompt('Resolution note, this is recorded in the audit log');
+    if (!note) return;
+
+    const formData = new FormData();
+    formData.append('report_id', report.ID);
+    formData.append('action', action);
+    formData.append('note', note);
+
+    const response = await fetch('/api/admin/resolve_report', {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: formData
+    });
+
+    if (!response.ok) {
+        alert(await response.text() || 'Failed to resolve report');
+        return;
+    }
+
+    loadReports(currentSkip);
+}
+
+function renderReport(template, report) {
+    const row = template.content.cloneNode(true);
+
+    const createdAt = row.querySelector('.created-at');
+    createdAt.textContent = relativeTime(report.CreatedAt);
+    createdAt.title = formatTimeDate(report.CreatedAt);
+
+    const link = row.querySelector('.file-link');
+    link.href = report.FileUrl;
+    link.textContent = report.FileName;
+
+    const uploader = row.querySelector('.uploader');
+    uploader.href = `/admin/files?uploader_id=${report.UploaderID}`;
+    uploader.textContent = `User ${report.UploaderID}`;
+
+    row.querySelector('.category').textContent = report.Category;
+    row.querySelector('.description').textContent = report.Description;
+
+    const status = row.querySelector('.status');
+    if (report.Status === 'OPEN') {
+        status.textContent = 'Open';
+    } else {
+        status.textContent = `${report.Resolution} by user ${report.ResolvedByID}`;
+        status.title = report.ResolutionNote;
+        row.querySelector('.actions').replaceChildren();
+    }
+
+    for (const button of row.querySelectorAll('button[data-action]')) {
+        button.addEventListener('click', () => resolveReport(button.dataset.action, report));
+    }
+
+    return row;
+}
+
+async function loadReports(skip = 0) {
+    const entries = document.getElementById('reports-entries');
+
+    const params = new URLSearchParams(new FormData(document.getElementById('report-filter')));
+    params.set('skip', skip);
+
+    const response = await fetch(`/api/admin/reports?${params}`, {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        entries.textContent = 'Failed to load reports';
+        return;
+    }
+
+    const data = await response.json();
+    currentSkip = skip;
+
+    const template = document.getElementById('report-entry-template');
+    entries.replaceChildren(...(data.Reports || []).map(report => renderReport(template, report)));
+
+    document.getElementById('reports-count').textContent = data.Count === 1 ? '1 report' : `${data.Count} reports`;
+
+    const totalPages = Math.max(1, Math.ceil(data.Count / reportsPerPage));
+    const page = Math.floor(currentSkip / reportsPerPage) + 1;
+    document.getElementById('reports-page-info').textContent = `Page ${page} of ${totalPages}`;
+    document.getElementById('reports-prev').disabled = currentSkip === 0;
+    document.getElementById('reports-next').disabled = currentSkip + reportsPerPage >= data.Count;
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    document.getElementById('report-filter').addEventListener('submit', (e) => {
+        e.preventDefault();
+        loadReports(0);
+    });
+
+    document.getElementById('reports-prev').addEventListener('click', () => {
+        loadReports(Math.max(0, currentSkip - reportsPerPage));
+    });
+
+    document.getElementById('reports-next').addEventListener('click', () => {
+        loadReports(currentSkip + reportsPerPage);
+    });
+
+    loadReports(0);
+});
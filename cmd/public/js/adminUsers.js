@@ -0,0 +1,156 @@
+import { formatTimeDate, humanizeBytes, relativeTime } from './utils.js';
+
+const usersPerPage = 20;
+let currentSkip = 0;
+
+// Go durations are serialized as nanoseconds, this formats them back to something time.ParseDuration accepts
+function formatDuration(nanoseconds) {
+    const hours = nanoseconds / 3.6e12;
+    if (Number.isInteger(hours)) {
+        return `${hours}h`;
+    }
+
+    return `${Math.round(nanoseconds / 6e10)}m`;
+}
+
+function setValue(card, selector, text, title) {
+    const element = card.querySelector(selector);
+    element.textContent = text;
+    if (title) {
+        element.title = title;
+    }
+}
+
+function renderUser(template, user) {
+    const card = template.content.cloneNode(true);
+
+    setValue(card, '.user-title', `User ${user.ID}`);
+    setValue(card, '.role-badge', user.AccountType);
+    if (!user.You) {
+        card.querySelector('.you-badge').remove();
+    }
+
+    setValue(card, '.github-username', user.GithubUsername);
+    setValue(card, '.created-at', relativeTime(user.CreatedAt), formatTimeDate(user.CreatedAt));
+
+    if (new Date(user.LastActivity).getFullYear() > 1) {
+        setValue(card, '.last-activity', relativeTime(user.LastActivity), formatTimeDate(user.LastActivity));
+    } else {
+        setValue(card, '.last-activity', 'Never');
+    }
+
+    setValue(card, '.invited-by', user.InvitedBy);
+
+    let spaceUsed = humanizeBytes(user.SpaceUsed);
+    if (user.Quota.MaxStorage) {
+        spaceUsed += ` / ${humanizeBytes(user.Quota.MaxStorage)}`;
+    }
+    setValue(card, '.space-used', spaceUsed, `${user.SpaceUsed} bytes`);
+
+    let filesUploaded = `${user.FilesUploaded}`;
+    if (user.Quota.MaxFiles) {
+        filesUploaded += ` / ${user.Quota.MaxFiles}`;
+    }
+    setValue(card, '.files-uploaded', filesUploaded);
+
+    setValue(card, '.tokens-count', `${user.SessionsCount}/${user.UploadTokensCount}`);
+
+    if (user.Suspended) {
+        let suspension = user.SuspensionReason;
+        if (new Date(user.SuspendedUntil).getFullYear() > 1) {
+            suspension += ` (until ${formatTimeDate(user.SuspendedUntil)})`;
+        }
+        if (user.SuspensionHidesFiles) {
+            suspension += ', files hidden';
+        }
+        setValue(card, '.suspension', suspension, `Since ${formatTimeDate(user.SuspendedAt)}`);
+        card.querySelector('.suspend-form')?.remove();
+    } else {
+        card.querySelector('.suspension-entry').remove();
+        card.querySelector('.unsuspend-form')?.remove();
+    }
+
+    if (user.Quota.MaxRetention) {
+        setValue(card, '.max-retention', formatDuration(user.Quota.MaxRetention));
+    } else {
+        card.querySelector('.max-retention-entry').remove();
+    }
+
+    // Deleting yourself or changing your own role isn't allowed
+    if (user.You) {
+        card.querySelectorAll('.not-you').forEach(form => form.remove());
+    }
+
+    card.querySelectorAll('input[name="id"]').forEach(input => input.value = user.ID);
+    const browseFilesLink = card.querySelector('.browse-files-link');
+    if (browseFilesLink) browseFilesLink.href = `/admin/files?uploader_id=${user.ID}`;
+
+    const quotaForm = card.querySelector('form[action="/api/admin/set_quota"]');
+    if (quotaForm) {
+        if (user.MaxStorage !== null) quotaForm.max_storage.value = humanizeBytes(user.MaxStorage);
+        if (user.MaxFiles !== null) quotaForm.max_files.value = user.MaxFiles;
+        if (user.MaxRetention !== null) quotaForm.max_retention.value = formatDuration(user.MaxRetention);
+    }
+
+    const roleForm = card.querySelector('form[action="/api/admin/set_role"]');
+    if (roleForm) {
+        roleForm.account_type.value = user.AccountType;
+    }
+
+    return card;
+}
+
+async function loadUsers(skip = 0) {
+    const usersGrid = document.querySelector('.users-grid');
+    const form = document.getElementById('users-filter');
+
+    const params = new URLSearchParams();
+    for (const [key, value] of new FormData(form)) {
+        if (value !== '') {
+            params.append(key, value);
+        }
+    }
+    params.set('skip', skip);
+
+    const response = await fetch(`/api/admin/users?${params}`, {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        usersGrid.textContent = 'Failed to load users';
+        return;
+    }
+
+    const data = await response.json();
+    currentSkip = skip;
+
+    const template = document.getElementById('user-card-template');
+    usersGrid.replaceChildren(...data.Users.map(user => renderUser(template, user)));
+
+    if (data.Users.length === 0) {
+        usersGrid.textContent = 'No users found';
+    }
+
+    const totalPages = Math.max(1, Math.ceil(data.Count / usersPerPage));
+    const page = Math.floor(currentSkip / usersPerPage) + 1;
+    document.getElementById('users-page-info').textContent = `Page ${page} of ${totalPages}`;
+    document.getElementById('users-prev').disabled = currentSkip === 0;
+    document.getElementById('users-next').disabled = currentSkip + usersPerPage >= data.Count;
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    document.getElementById('users-filter').addEventListener('submit', (e) => {
+        e.preventDefault();
+        loadUsers(0);
+    });
+
+    document.getElementById('users-prev').addEventListener('click', () => {
+        loadUsers(Math.max(0, currentSkip - usersPerPage));
+    });
+
+    document.getElementById('users-next').addEventListener('click', () => {
+        loadUsers(currentSkip + usersPerPage);
+    });
+
+    loadUsers(0);
+});
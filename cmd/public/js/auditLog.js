@@ -0,0 +1,94 @@
+import { formatTimeDate, relativeTime } from './utils.js';
+
+const entriesPerPage = 50;
+let currentSkip = 0;
+let totalEntries = 0;
+
+function filterParams() {
+    const form = document.getElementById('audit-log-filter');
+    const params = new URLSearchParams();
+
+    for (const [key, value] of new FormData(form)) {
+        if (value !== '') {
+            params.append(key, value);
+        }
+    }
+
+    return params;
+}
+
+function updateExportLinks() {
+    for (const format of ['json', 'csv']) {
+        const params = filterParams();
+        params.set('format', format);
+        document.getElementById(`audit-log-export-${format}`).href = `/api/admin/audit_log/export?${params}`;
+    }
+}
+
+async function loadAuditLog(skip = 0) {
+    const entriesElement = document.getElementById('audit-log-entries');
+
+    const params = filterParams();
+    params.set('skip', skip);
+
+    const response = await fetch(`/api/admin/audit_log?${params}`, {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        entriesElement.textContent = 'Failed to load audit log';
+        return;
+    }
+
+    const data = await response.json();
+    currentSkip = skip;
+    totalEntries = data.Count;
+
+    const template = document.getElementById('audit-log-entry-template');
+    entriesElement.replaceChildren();
+
+    for (const entry of data.Entries || []) {
+        const row = template.content.cloneNode(true);
+
+        const time = row.querySelector('.time');
+        time.textContent = relativeTime(entry.CreatedAt);
+        time.title = formatTimeDate(entry.CreatedAt);
+
+        row.querySelector('.actor').textContent = entry.ActorID ? `User ${entry.ActorID}` : '-';
+        row.querySelector('.action').textContent = entry.Action;
+        row.querySelector('.target').textContent = entry.TargetType ? `${entry.TargetType} ${entry.TargetID}` : '-';
+
+        const ipHash = row.querySelector('.ip-hash');
+        ipHash.textContent = entry.IpHash.slice(0, 12);
+        ipHash.title = entry.IpHash;
+
+        row.querySelector('.details').textContent = entry.Details;
+
+        entriesElement.appendChild(row);
+    }
+
+    const totalPages = Math.max(1, Math.ceil(totalEntries / entriesPerPage));
+    const page = Math.floor(currentSkip / entriesPerPage) + 1;
+    document.getElementById('audit-log-page-info').textContent = `Page ${page} of ${totalPages}`;
+    document.getElementById('audit-log-prev').disabled = currentSkip === 0;
+    document.getElementById('audit-log-next').disabled = currentSkip + entriesPerPage >= totalEntries;
+
+    updateExportLinks();
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    document.getElementById('audit-log-filter').addEventListener('submit', (e) => {
+        e.preventDefault();
+        loadAuditLog(0);
+    });
+
+    document.getElementById('audit-log-prev').addEventListener('click', () => {
+        loadAuditLog(Math.max(0, currentSkip - entriesPerPage));
+    });
+
+    document.getElementById('audit-log-next').addEventListener('click', () => {
+        loadAuditLog(currentSkip + entriesPerPage);
+    });
+
+    loadAuditLog(0);
+});
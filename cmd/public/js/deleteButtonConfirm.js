@@ -1,15 +1,11 @@
-const deleteButtons = document.querySelectorAll('.delete-button');
-
-for (let button of deleteButtons) {
-    if (!button.dataset.confirm) {
-        continue;
+// Delegated so buttons rendered later by javascript are covered as well
+document.addEventListener('click', function(e) {
+    const button = e.target.closest('.delete-button');
+    if (!button || !button.dataset.confirm) {
+        return;
     }
 
-    button.addEventListener('click', function(e) {
-        const confirmMessage = e.target.dataset.confirm;
-
-        if (!confirm(confirmMessage)) {
-            e.preventDefault();
-        }
-    });
-}
\ No newline at end of file
+    if (!confirm(button.dataset.confirm)) {
+        e.preventDefault();
+    }
+});
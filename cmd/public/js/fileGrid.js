@@ -1,10 +1,13 @@
 import { reloadCurrentPage, decrementTotalFiles } from './fileRenderer.js';
 import { loadFileStats } from './fileStats.js';
+import { loadTrash } from './trash.js';
+import { csrfHeaders } from './utils.js';
+import { getSelectedFiles, clearFileSelection } from './fileSelection.js';
 
 function deleteFileGrid(elem) {
     const filename = elem.parentElement.dataset.filename;
 
-    if (confirm(`Are you sure you want to delete "${filename}"?`)) {
+    if (confirm(`Are you sure you want to move "${filename}" to trash?`)) {
         deleteFileByName(filename);
     }
 }
@@ -17,6 +20,7 @@ export async function deleteFileByName(filename) {
 
     const response = await fetch('/api/file/delete', {
         method: 'POST',
+        headers: csrfHeaders(),
         body: formData
     });
 
@@ -24,6 +28,7 @@ export async function deleteFileByName(filename) {
         decrementTotalFiles();
         reloadCurrentPage();
         loadFileStats();
+        loadTrash();
         return true;
     } else {
         alert('Failed to delete file');
@@ -54,4 +59,77 @@ export function setVisibilityGrid(filename, isPublic) {
             break;
         }
     }
-}
\ No newline at end of file
+}
+
+const bulkConfirmTexts = {
+    delete: count => `Are you sure you want to move ${count} files to trash?`,
+    set_public: count => `Make ${count} files public?`,
+    set_private: count => `Make ${count} files private?`,
+};
+
+async function bulkFileAction(action) {
+    const filenames = getSelectedFiles();
+    if (filenames.length === 0) return;
+
+    const formData = new FormData();
+    formData.append('action', action);
+    for (const filename of filenames) {
+        formData.append('file_names', filename);
+    }
+
+    if (action === 'set_expiry') {
+        const expiryDate = document.getElementById('selection-expiry-date').value;
+        if (!expiryDate && !confirm(`Remove the expiry of ${filenames.length} files?`)) return;
+
+        formData.append('expiry_date', expiryDate);
+    } else if (!confirm(bulkConfirmTexts[action](filenames.length))) {
+        return;
+    }
+
+    const response = await fetch('/api/account/bulk_files', {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: formData
+    });
+
+    if (!response.ok) {
+        alert(await response.text() || 'Failed to update files');
+        return;
+    }
+
+    const output = await response.json();
+    const failed = output.results.filter(result => !result.ok);
+    if (failed.length > 0) {
+        alert(`${failed.length} files couldn't be updated:\n` + failed.map(result => `${result.file_name}: ${result.error}`).join('\n'));
+    }
+
+    clearFileSelection();
+    reloadCurrentPage();
+
+    if (action === 'delete') {
+        loadFileStats();
+        loadTrash();
+    }
+}
+
+window.bulkFileAction = bulkFileAction;
+
+// Submitted as a regular form so the browser handles the download
+function downloadSelectedFiles() {
+    const form = document.getElementById('download-files');
+
+    const fields = [];
+    for (const filename of getSelectedFiles()) {
+        const field = document.createElement('input');
+        field.type = 'hidden';
+        field.name = 'file_names';
+        field.value = filename;
+        fields.push(field);
+    }
+
+    form.append(...fields);
+    form.submit();
+    fields.forEach(field => field.remove());
+}
+
+window.downloadSelectedFiles = downloadSelectedFiles;
@@ -1,4 +1,5 @@
 import { deleteFileByName, setVisibilityGrid } from './fileGrid.js';
+import { csrfHeaders } from './utils.js';
 
 const modal = document.getElementById('file-modal');
 
@@ -6,6 +7,9 @@ const togglePublicButton = document.getElementById('file-modal-toggle-public-but
 
 const fileModalDeleteButton = document.getElementById('file-modal-delete-button');
 
+// Public files have a page with a report link, shareable instead of the raw file
+const fileModalPageLink = document.getElementById('file-modal-page-link');
+
 const filePreviewImage = document.getElementById('file-preview-image');
 const filePreviewVideo = document.getElementById('file-preview-video');
 const filePreviewAudio = document.getElementById('file-preview-audio');
@@ -45,21 +49,22 @@ function showModal(elem) {
     filePreviewGeneric.style.display = 'none';
 
     if (isimage) {
-        filePreviewImage.src = `/${elem.parentElement.dataset.filename}`;
+        filePreviewImage.src = elem.parentElement.dataset.url;
         filePreviewImage.style.display = 'block';
     } else if (isvideo) {
-        filePreviewVideo.src = `/${elem.parentElement.dataset.filename}`;
+        filePreviewVideo.src = elem.parentElement.dataset.url;
         filePreviewVideo.style.display = 'block';
     } else if (isaudio) {
-        filePreviewAudio.src = `/${elem.parentElement.dataset.filename}`;
+        filePreviewAudio.src = elem.parentElement.dataset.url;
         filePreviewAudio.style.display = 'block';
     } else {
-        filePreviewGeneric.href = `/${elem.parentElement.dataset.filename}`;
+        filePreviewGeneric.href = elem.parentElement.dataset.url;
         filePreviewGeneric.style.display = 'block';
     }
 
     fileModalFilename.textContent = elem.parentElement.dataset.filename;
-    fileModalFilenameUrl.href = `/${elem.parentElement.dataset.filename}`;
+    fileModalPageLink.href = `/view/${encodeURIComponent(elem.parentElement.dataset.filename)}`;
+    fileModalFilenameUrl.href = elem.parentElement.dataset.url;
 
     if (elem.parentElement.dataset.originalfilename !== '') {
         fileModalOriginalFilename.parentElement.style.display = 'block';
@@ -99,6 +104,7 @@ function showModal(elem) {
 
         const response = await fetch('/api/account/toggle_file_public', {
             method: 'POST',
+            headers: csrfHeaders(),
             body: formData
         });
 
@@ -140,10 +146,12 @@ function setVisibility(isPublic) {
         fileModalVisibility.textContent = 'Public';
         fileModalVisibilityIcon.href.baseVal = '/public/assets/lucide-sprite.svg#lock-open';
         togglePublicButton.textContent = 'Make Private';
+        fileModalPageLink.style.display = '';
     } else {
         fileModalVisibility.textContent = 'Private';
         fileModalVisibilityIcon.href.baseVal = '/public/assets/lucide-sprite.svg#lock';
         togglePublicButton.textContent = 'Make Public';
+        fileModalPageLink.style.display = 'none';
     }
 }
 
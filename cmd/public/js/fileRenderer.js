@@ -1,4 +1,11 @@
 import { formatTimeDate, relativeTime, humanizeBytes, mimeIsImage, mimeIsVideo, mimeIsAudio } from './utils.js';
+import { isFileSelected } from './fileSelection.js';
+
+const moderationTexts = {
+    HIDDEN: 'Hidden by a moderator',
+    QUARANTINED: 'Quarantined by a moderator',
+    DISABLED: 'Disabled after a report',
+};
 
 function createFileEntry(file) {
     const template = document.getElementById('file-entry-template');
@@ -7,6 +14,7 @@ function createFileEntry(file) {
     // Used by file modal
     entry.dataset.filename = file.FileName;
     entry.dataset.originalfilename = file.OriginalFileName || '';
+    entry.dataset.url = file.Url;
     entry.dataset.filesize = humanizeBytes(file.FileSize);
     entry.dataset.filesizebytes = file.FileSize;
     entry.dataset.isimage = mimeIsImage(file.MimeType);
@@ -28,13 +36,18 @@ function createFileEntry(file) {
 
     entry.querySelector('.file-name').textContent = file.OriginalFileName || file.FileName;
 
+    if (isFileSelected(file.FileName)) {
+        entry.classList.add('selected');
+        entry.querySelector('.file-select input').checked = true;
+    }
+
     if (mimeIsImage(file.MimeType)) {
         const img = entry.querySelector('.preview-image');
-        img.src = `/${file.FileName}`;
+        img.src = file.Url;
         img.style.display = 'block';
     } else if (mimeIsVideo(file.MimeType)) {
         const video = entry.querySelector('.preview-video');
-        video.src = `/${file.FileName}`;
+        video.src = file.Url;
         video.style.display = 'block';
     } else if (mimeIsAudio(file.MimeType)) {
         const audio = entry.querySelector('.preview-audio');
@@ -56,7 +69,10 @@ function createFileEntry(file) {
 
     const visibilityIcon = entry.querySelector('.visibility-icon');
     const visibilityText = entry.querySelector('.visibility-text');
-    if (file.Public) {
+    if (file.ModerationStatus) {
+        visibilityIcon.href.baseVal = '/public/assets/lucide-sprite.svg#lock';
+        visibilityText.textContent = moderationTexts[file.ModerationStatus] || 'Hidden by a moderator';
+    } else if (file.Public) {
         visibilityIcon.href.baseVal = '/public/assets/lucide-sprite.svg#lock-open';
         visibilityText.textContent = 'Public';
     } else {
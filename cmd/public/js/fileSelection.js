@@ -0,0 +1,51 @@
+// Selected file names are kept while paging through the grid
+const selectedFiles = new Set();
+
+export function isFileSelected(filename) {
+    return selectedFiles.has(filename);
+}
+
+export function getSelectedFiles() {
+    return [...selectedFiles];
+}
+
+function toggleFileSelection(elem) {
+    const entry = elem.closest('.file-entry');
+    const filename = entry.dataset.filename;
+
+    if (elem.checked) {
+        selectedFiles.add(filename);
+    } else {
+        selectedFiles.delete(filename);
+    }
+
+    entry.classList.toggle('selected', elem.checked);
+    updateSelectionBar();
+}
+
+window.toggleFileSelection = toggleFileSelection;
+
+export function clearFileSelection() {
+    selectedFiles.clear();
+
+    for (const entry of document.querySelectorAll('.file-entry.selected')) {
+        entry.classList.remove('selected');
+        entry.querySelector('.file-select input').checked = false;
+    }
+
+    updateSelectionBar();
+}
+
+window.clearFileSelection = clearFileSelection;
+
+function updateSelectionBar() {
+    const selectionBar = document.getElementById('selection-bar');
+    if (selectedFiles.size === 0) {
+        selectionBar.style.display = 'none';
+        return;
+    }
+
+    selectionBar.style.display = 'flex';
+    document.getElementById('selection-count').textContent =
+        selectedFiles.size === 1 ? '1 file selected' : `${selectedFiles.size} files selected`;
+}
@@ -17,8 +17,17 @@ export async function loadFileStats() {
     const count = data.count || 0;
     const sizeTotal = data.size_total || 0;
     
-    const filesText = count === 1 ? '1 file' : `${count} files`;
-    filesStatsElement.textContent = `${filesText} • ${humanizeBytes(sizeTotal)}`;
+    let filesText = count === 1 ? '1 file' : `${count} files`;
+    if (data.max_files) {
+        filesText += ` of ${data.max_files}`;
+    }
+
+    let sizeText = humanizeBytes(sizeTotal);
+    if (data.max_storage) {
+        sizeText += ` of ${humanizeBytes(data.max_storage)}`;
+    }
+
+    filesStatsElement.textContent = `${filesText} • ${sizeText}`;
 }
 
 document.addEventListener('DOMContentLoaded', loadFileStats);
@@ -0,0 +1,68 @@
+import { reloadCurrentPage } from './fileRenderer.js';
+import { loadFileStats } from './fileStats.js';
+import { csrfHeaders, formatTimeDate, humanizeBytes, relativeTime } from './utils.js';
+
+export async function loadTrash() {
+    const trashList = document.getElementById('trash-list');
+    if (!trashList) return;
+
+    const response = await fetch('/api/account/trash', {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        trashList.textContent = 'Failed to load trash';
+        return;
+    }
+
+    const files = await response.json();
+    trashList.replaceChildren();
+
+    if (files.length === 0) {
+        const empty = document.createElement('p');
+        empty.textContent = 'Trash is empty';
+        trashList.appendChild(empty);
+        return;
+    }
+
+    const template = document.getElementById('trash-entry-template');
+    for (const file of files) {
+        const entry = template.content.cloneNode(true);
+
+        entry.querySelector('.trash-entry').dataset.filename = file.FileName;
+        entry.querySelector('.original-file-name').textContent = file.OriginalFileName || file.FileName;
+        entry.querySelector('.file-size').textContent = humanizeBytes(file.FileSize);
+
+        const purgeText = entry.querySelector('.purge-text');
+        purgeText.textContent = `Deleted permanently ${relativeTime(file.PurgeAt)}`;
+        purgeText.title = formatTimeDate(file.PurgeAt);
+
+        trashList.appendChild(entry);
+    }
+}
+
+async function restoreFile(elem) {
+    const filename = elem.closest('.trash-entry').dataset.filename;
+
+    const formData = new FormData();
+    formData.append('file_name', filename);
+
+    const response = await fetch('/api/account/restore_file', {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: formData
+    });
+
+    if (!response.ok) {
+        alert(await response.text() || 'Failed to restore file');
+        return;
+    }
+
+    loadTrash();
+    reloadCurrentPage();
+    loadFileStats();
+}
+
+window.restoreFile = restoreFile;
+
+document.addEventListener('DOMContentLoaded', loadTrash);
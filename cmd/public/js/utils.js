@@ -69,4 +69,10 @@ export function mimeIsVideo(mimeType) {
 
 export function mimeIsAudio(mimeType) {
     return mimeType && mimeType.startsWith('audio/');
+}
+
+// Headers for state changing api requests, the server compares it against the csrf cookie
+export function csrfHeaders() {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? { 'X-CSRF-Token': meta.content } : {};
 }
\ No newline at end of file
@@ -0,0 +1,183 @@
+import { csrfHeaders, formatTimeDate, relativeTime } from './utils.js';
+
+const deliveriesPerPage = 25;
+
+// The same panel manages account webhooks on /user and admin webhooks on /admin/webhooks
+function apiBase() {
+    return document.getElementById('webhooks').dataset.api;
+}
+
+async function postForm(url, formData) {
+    const response = await fetch(url, {
+        method: 'POST',
+        headers: csrfHeaders(),
+        body: formData
+    });
+
+    const text = await response.text();
+    if (!response.ok) {
+        alert(text || 'Request failed');
+        return null;
+    }
+
+    return text;
+}
+
+async function deleteWebhook(webhook) {
+    if (!confirm(`Are you sure you want to delete the webhook for ${webhook.Url}?`)) return;
+
+    const formData = new FormData();
+    formData.append('id', webhook.ID);
+
+    if (await postForm(`${apiBase()}/delete_webhook`, formData) !== null) {
+        loadWebhooks();
+    }
+}
+
+async function redeliver(entry, webhook, delivery, skip) {
+    const formData = new FormData();
+    formData.append('delivery_id', delivery.ID);
+
+    if (await postForm(`${apiBase()}/redeliver_webhook`, formData) !== null) {
+        loadDeliveries(entry, webhook, skip);
+    }
+}
+
+function renderDelivery(template, entry, webhook, delivery, skip) {
+    const row = template.content.cloneNode(true);
+
+    row.querySelector('.event').textContent = delivery.Event;
+
+    const status = row.querySelector('.status');
+    status.textContent = delivery.Status;
+    status.title = delivery.LastError;
+    if (delivery.Status === 'PENDING' && delivery.Attempts > 0) {
+        status.textContent = `Retrying ${relativeTime(delivery.NextAttemptAt)}`;
+    }
+
+    row.querySelector('.attempts').textContent = delivery.Attempts;
+    row.querySelector('.response').textContent = delivery.ResponseStatus || '';
+
+    const createdAt = row.querySelector('.created-at');
+    createdAt.textContent = relativeTime(delivery.CreatedAt);
+    createdAt.title = formatTimeDate(delivery.CreatedAt);
+
+    const redeliverButton = row.querySelector('.redeliver-button');
+    redeliverButton.disabled = delivery.Status === 'PENDING';
+    redeliverButton.addEventListener('click', () => redeliver(entry, webhook, delivery, skip));
+
+    return row;
+}
+
+async function loadDeliveries(entry, webhook, skip = 0) {
+    const rows = entry.querySelector('.webhook-deliveries-table tbody');
+
+    const params = new URLSearchParams({ id: webhook.ID, skip });
+    const response = await fetch(`${apiBase()}/webhook_deliveries?${params}`, {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        rows.textContent = 'Failed to load deliveries';
+        return;
+    }
+
+    const data = await response.json();
+
+    const template = document.getElementById('webhook-delivery-template');
+    rows.replaceChildren(...(data.Deliveries || []).map(delivery => renderDelivery(template, entry, webhook, delivery, skip)));
+
+    const totalPages = Math.max(1, Math.ceil(data.Count / deliveriesPerPage));
+    const page = Math.floor(skip / deliveriesPerPage) + 1;
+    entry.querySelector('.webhook-deliveries-page-info').textContent = `Page ${page} of ${totalPages}`;
+
+    const prev = entry.querySelector('.webhook-deliveries-prev');
+    const next = entry.querySelector('.webhook-deliveries-next');
+    prev.disabled = skip === 0;
+    next.disabled = skip + deliveriesPerPage >= data.Count;
+    prev.onclick = () => loadDeliveries(entry, webhook, Math.max(0, skip - deliveriesPerPage));
+    next.onclick = () => loadDeliveries(entry, webhook, skip + deliveriesPerPage);
+}
+
+function renderWebhook(template, webhook) {
+    const fragment = template.content.cloneNode(true);
+    const entry = fragment.querySelector('.webhook-entry');
+
+    entry.querySelector('.webhook-url').textContent = webhook.Url;
+    entry.querySelector('.webhook-events').textContent = webhook.Events.split(',').join(', ');
+
+    const deliveries = entry.querySelector('.webhook-deliveries');
+    entry.querySelector('.webhook-deliveries-button').addEventListener('click', () => {
+        deliveries.hidden = !deliveries.hidden;
+        if (!deliveries.hidden) loadDeliveries(entry, webhook);
+    });
+
+    entry.querySelector('.webhook-delete-button').addEventListener('click', () => deleteWebhook(webhook));
+
+    return fragment;
+}
+
+function renderEventOptions(events) {
+    const options = document.getElementById('webhook-event-options');
+    if (options.childElementCount > 0) return;
+
+    for (const event of events) {
+        const label = document.createElement('label');
+        const checkbox = document.createElement('input');
+        checkbox.type = 'checkbox';
+        checkbox.name = 'events';
+        checkbox.value = event;
+        checkbox.checked = true;
+
+        label.append(checkbox, ` ${event}`);
+        options.appendChild(label);
+    }
+}
+
+async function loadWebhooks() {
+    const list = document.getElementById('webhooks-list');
+
+    const response = await fetch(`${apiBase()}/webhooks`, {
+        method: 'GET',
+    });
+
+    if (!response.ok) {
+        list.textContent = 'Failed to load webhooks';
+        return;
+    }
+
+    const data = await response.json();
+    renderEventOptions(data.Events || []);
+
+    if (!data.Webhooks || data.Webhooks.length === 0) {
+        const empty = document.createElement('p');
+        empty.textContent = 'No webhooks yet';
+        list.replaceChildren(empty);
+        return;
+    }
+
+    const template = document.getElementById('webhook-entry-template');
+    list.replaceChildren(...data.Webhooks.map(webhook => renderWebhook(template, webhook)));
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    if (!document.getElementById('webhooks')) return;
+
+    const form = document.getElementById('create-webhook-form');
+    form.addEventListener('submit', async (e) => {
+        e.preventDefault();
+
+        const secret = await postForm(`${apiBase()}/create_webhook`, new FormData(form));
+        if (secret === null) return;
+
+        // Only shown this once, same as upload tokens
+        const secretText = document.getElementById('webhook-secret');
+        secretText.querySelector('code').textContent = secret;
+        secretText.hidden = false;
+
+        form.querySelector('input[name="url"]').value = '';
+        loadWebhooks();
+    });
+
+    loadWebhooks();
+});
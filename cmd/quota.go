@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Per role limits from the config, 0 means unlimited
+type roleConfig struct {
+	MaxStorage   uint          `toml:"max_storage"`   // Total bytes an account can store
+	MaxFiles     uint          `toml:"max_files"`     // Total amount of files an account can have
+	MaxRetention time.Duration `toml:"max_retention"` // How long files can be kept, e.g "720h"
+
+	DefaultExpiry time.Duration `toml:"default_expiry"` // Overrides the global default expiry
+
+	AllowedMimeTypes []string `toml:"allowed_mime_types"` // Replaces the global allowlist, see mimePolicyConfig
+	DeniedMimeTypes  []string `toml:"denied_mime_types"`  // Added on top of the global denylist
+}
+
+type quota struct {
+	MaxStorage   uint
+	MaxFiles     uint
+	MaxRetention time.Duration
+}
+
+var (
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+	ErrFileQuotaExceeded    = errors.New("file count quota exceeded")
+	ErrFileLargerThanQuota  = errors.New("file is larger than the storage quota")
+)
+
+// Account overrides take priority over the role defaults
+func (app *Application) quotaFor(account Accounts) (q quota) {
+	roleDefaults := app.config.Roles[account.AccountType]
+
+	q = quota{
+		MaxStorage:   roleDefaults.MaxStorage,
+		MaxFiles:     roleDefaults.MaxFiles,
+		MaxRetention: roleDefaults.MaxRetention,
+	}
+
+	if account.MaxStorage != nil {
+		q.MaxStorage = *account.MaxStorage
+	}
+
+	if account.MaxFiles != nil {
+		q.MaxFiles = *account.MaxFiles
+	}
+
+	if account.MaxRetention != nil {
+		q.MaxRetention = *account.MaxRetention
+	}
+
+	return
+}
+
+// Makes sure a new file of fileSize bytes fits into the accounts quota
+func (app *Application) checkQuota(ctx context.Context, account Accounts, fileSize uint) (err error) {
+	q := app.quotaFor(account)
+	if q.MaxStorage == 0 && q.MaxFiles == 0 {
+		return
+	}
+
+	if q.MaxStorage > 0 && fileSize > q.MaxStorage {
+		return ErrFileLargerThanQuota
+	}
+
+	totalFiles, totalStorage, err := app.db.withContext(ctx).getFileStats(account.ID)
+	if err != nil {
+		return
+	}
+
+	return q.exceeded(totalFiles+1, totalStorage+fileSize)
+}
+
+// Returns an error when the totals of an account go over the quota
+func (q quota) exceeded(totalFiles, totalStorage uint) error {
+	if q.MaxFiles > 0 && totalFiles > q.MaxFiles {
+		return ErrFileQuotaExceeded
+	}
+
+	if q.MaxStorage > 0 && totalStorage > q.MaxStorage {
+		return ErrStorageQuotaExceeded
+	}
+
+	return nil
+}
@@ -0,0 +1,386 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type ReportStatus string
+
+const (
+	ReportOpen      ReportStatus = "OPEN"
+	ReportDismissed ReportStatus = "DISMISSED" // Nothing was done to the file
+	ReportResolved  ReportStatus = "RESOLVED"  // Action was taken, see Resolution
+)
+
+var ReportStatuses = []ReportStatus{ReportOpen, ReportDismissed, ReportResolved}
+
+var ReportCategories = []string{"illegal", "copyright", "abuse", "spam", "other"}
+
+// Ways a moderator can resolve a report
+const (
+	ReportActionDismiss     = "dismiss"
+	ReportActionDeleteFile  = "delete_file"
+	ReportActionDisableFile = "disable_file"
+	ReportActionSuspend     = "suspend_uploader" // Suspends the uploader and hides their files
+)
+
+var reportActions = []string{ReportActionDismiss, ReportActionDeleteFile, ReportActionDisableFile, ReportActionSuspend}
+
+const maxReportDescriptionLength = 2000
+
+// Abuse report sent in by anyone viewing a file, file details are copied so the report still makes sense after the file is deleted
+type Reports struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+
+	FileID     uint `gorm:"index"`
+	FileName   string
+	UploaderID uint `gorm:"index"`
+
+	Category       string
+	Description    string
+	ReporterIpHash string `json:"-"`
+
+	Status         ReportStatus `gorm:"index;not null;default:'OPEN'"`
+	Resolution     string       // Action the report was resolved with
+	ResolutionNote string
+	ResolvedByID   uint      `gorm:"default:null"`
+	ResolvedAt     time.Time `gorm:"default:null"`
+
+	FileUrl string `gorm:"-"`
+}
+
+// Only publicly viewable files can be reported
+func reportable(file Files) bool {
+	return file.Public && file.ModerationStatus == "" && !file.Uploader.FilesHidden()
+}
+
+func reportTarget(id uint) auditTarget {
+	return auditTarget{Type: "report", ID: strconv.FormatUint(uint64(id), 10)}
+}
+
+func (app *Application) reportPage(c *gin.Context) {
+	_, account, loggedIn, err := app.validateAuthCookie(c)
+	if errors.Is(err, ErrInvalidAuthCookie) {
+		app.clearAuthCookie(c)
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	templateInput := gin.H{
+		"CurrentPage": "report",
+		"Branding":    app.config.Branding,
+		"Tagline":     app.config.Tagline,
+		"FileName":    c.Query("file"),
+		"Categories":  ReportCategories,
+	}
+
+	if loggedIn {
+		// For top bar
+		templateInput["LoggedIn"] = true
+		templateInput["AccountID"] = account.ID
+		templateInput["CanViewAdmin"] = account.AccountType.Can(PermViewAdmin)
+	}
+
+	c.HTML(http.StatusOK, "report.gohtml", templateInput)
+}
+
+// Page around a public file with a link to report it, the file itself is served as is by indexFiles
+func (app *Application) fileViewPage(c *gin.Context) {
+	_, account, loggedIn, err := app.validateAuthCookie(c)
+	if errors.Is(err, ErrInvalidAuthCookie) {
+		app.clearAuthCookie(c)
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	fileName := c.Param("file")
+
+	file, err := app.db.withContext(c).getFileByName(fileName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Ctx(c).Err(err).Msg("Failed to find file")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// Only files anyone can see get a page, the rest go through the access checks of indexFiles
+	if err != nil || !reportable(file) {
+		c.Redirect(http.StatusTemporaryRedirect, app.uiURL("/"+fileName))
+		return
+	}
+
+	templateInput := gin.H{
+		"CurrentPage": "file",
+		"Branding":    app.config.Branding,
+		"Tagline":     app.config.Tagline,
+		"File":        file,
+		"FileUrl":     app.permanentFileURL(file),
+		"ReportUrl":   "/report?file=" + url.QueryEscape(file.FileName),
+	}
+
+	if loggedIn {
+		// For top bar
+		templateInput["LoggedIn"] = true
+		templateInput["AccountID"] = account.ID
+		templateInput["CanViewAdmin"] = account.AccountType.Can(PermViewAdmin)
+	}
+
+	c.HTML(http.StatusOK, "file.gohtml", templateInput)
+}
+
+type reportFileAPIInput struct {
+	File        string `form:"file"` // File name or a link to the file
+	Category    string `form:"category"`
+	Description string `form:"description"`
+}
+
+// Public api for reporting a file, has its own stricter rate limit
+func (app *Application) reportFileAPI(c *gin.Context) {
+	var input reportFileAPIInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	fileName := path.Base(strings.TrimSpace(input.File))
+	if fileName == "." || fileName == "/" {
+		c.String(http.StatusBadRequest, "File is required")
+		return
+	}
+
+	if !slices.Contains(ReportCategories, input.Category) {
+		c.String(http.StatusBadRequest, "Invalid category")
+		return
+	}
+
+	if len(input.Description) > maxReportDescriptionLength {
+		c.String(http.StatusBadRequest, fmt.Sprintf("Description can be at most %d characters", maxReportDescriptionLength))
+		return
+	}
+
+	file, err := app.db.withContext(c).getFileByName(fileName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Ctx(c).Err(err).Msg("Failed to find reported file")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// Anything that can't be reported answers the same as a missing file so names can't be probed
+	if err != nil || !reportable(file) {
+		c.String(http.StatusNotFound, "File not found")
+		return
+	}
+
+	if err = app.db.withContext(c).createReport(Reports{
+		FileID:         file.ID,
+		FileName:       file.FileName,
+		UploaderID:     file.UploaderID,
+		Category:       input.Category,
+		Description:    strings.TrimSpace(input.Description),
+		ReporterIpHash: app.ipHash(c.ClientIP()),
+	}); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to create report")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.String(http.StatusOK, "Thank you, the report was sent to the moderators")
+}
+
+type AdminReportsApiInput struct {
+	Status string `form:"status,default=OPEN"` // Empty lists every report
+	Skip   uint   `form:"skip"`
+}
+
+type AdminReportsApiOutput struct {
+	Reports []Reports
+	Count   int64
+}
+
+// Api for the report queue, returns 25 reports at a time
+func (app *Application) adminReportsAPI(c *gin.Context) {
+	var input AdminReportsApiInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	status := ReportStatus(input.Status)
+	if status != "" && !slices.Contains(ReportStatuses, status) {
+		c.String(http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	var (
+		output AdminReportsApiOutput
+		err    error
+	)
+
+	if output.Reports, err = app.db.withContext(c).getReports(status, input.Skip, 25); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get reports")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if output.Count, err = app.db.withContext(c).countReports(status); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to count reports")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	for i := range output.Reports {
+		output.Reports[i].FileUrl = app.signedFileURL(output.Reports[i].FileName)
+	}
+
+	c.JSON(http.StatusOK, output)
+}
+
+type adminResolveReportInput struct {
+	ReportID uint   `form:"report_id"`
+	Action   string `form:"action"`
+	Note     string `form:"note"`
+}
+
+// Api for resolving a report, acting on the file or its uploader resolves every open report of that file
+func (app *Application) adminResolveReport(c *gin.Context) {
+	var input adminResolveReportInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if !slices.Contains(reportActions, input.Action) {
+		c.String(http.StatusBadRequest, "Invalid action")
+		return
+	}
+
+	if input.Note == "" {
+		c.AbortWithError(http.StatusBadRequest, ErrReasonRequired)
+		return
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if input.Action == ReportActionDeleteFile && !actor.AccountType.Can(PermDeleteFiles) ||
+		input.Action == ReportActionSuspend && !actor.AccountType.Can(PermSuspendUsers) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	report, err := app.db.withContext(c).getReport(input.ReportID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Report not found")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find report")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if report.Status != ReportOpen {
+		c.String(http.StatusConflict, "Report is already closed")
+		return
+	}
+
+	reason := fmt.Sprintf("report %d: %s", report.ID, input.Note)
+
+	switch input.Action {
+	case ReportActionDismiss:
+		err = app.db.withContext(c).closeReport(report.ID, ReportDismissed, input.Action, input.Note, actor.ID)
+	case ReportActionDeleteFile:
+		if err = app.purgeReportedFile(c, report); err == nil {
+			app.audit(c, actor.ID, AuditAdminDeleteFile, fileTarget(report.FileName), reason)
+			err = app.db.withContext(c).closeFileReports(report.FileID, input.Action, input.Note, actor.ID)
+		}
+	case ReportActionDisableFile:
+		if err = app.db.withContext(c).setFileModerationStatus(report.FileID, ModerationDisabled); err == nil {
+			app.audit(c, actor.ID, AuditDisableFile, fileTarget(report.FileName), reason)
+			err = app.db.withContext(c).closeFileReports(report.FileID, input.Action, input.Note, actor.ID)
+		}
+	case ReportActionSuspend:
+		if report.UploaderID == actor.ID {
+			c.AbortWithError(http.StatusBadRequest, ErrCantSuspendSelf)
+			return
+		}
+
+		if err = app.suspendAccount(c, report.UploaderID, reason, time.Time{}, true); err == nil {
+			app.audit(c, actor.ID, AuditSuspendUser, accountTarget(report.UploaderID), reason+", files hidden")
+			err = app.db.withContext(c).closeFileReports(report.FileID, input.Action, input.Note, actor.ID)
+		}
+	}
+
+	if err != nil {
+		log.Ctx(c).Err(err).Str("action", input.Action).Msg("Failed to resolve report")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditResolveReport, reportTarget(report.ID), fmt.Sprintf("%s: %s", input.Action, input.Note))
+
+	c.String(http.StatusOK, "Report resolved")
+}
+
+var ErrPurgeFailed = errors.New("failed to purge file")
+
+// Files in the trash are purged too, the file could also have been purged in the meantime in which case there is nothing to do
+func (app *Application) purgeReportedFile(ctx context.Context, report Reports) error {
+	file, err := app.db.withContext(ctx).getFileByIDIncludingTrash(report.FileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if purged := app.purgeFiles(ctx, []Files{file}); len(purged) == 0 {
+		return ErrPurgeFailed
+	}
+
+	return nil
+}
+
+func (app *Application) adminReportsPage(c *gin.Context) {
+	_, account, loggedIn, err := app.validateAuthCookie(c)
+	if errors.Is(err, ErrInvalidAuthCookie) {
+		app.clearAuthCookie(c)
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if !loggedIn || !account.AccountType.Can(PermHandleReports) {
+		c.Redirect(http.StatusTemporaryRedirect, "/login")
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_reports.gohtml", gin.H{
+		"CurrentPage":  "admin",
+		"Branding":     app.config.Branding,
+		"Tagline":      app.config.Tagline,
+		"LoggedIn":     true,
+		"AccountID":    account.ID,
+		"CanViewAdmin": true,
+		"CsrfToken":    app.csrfToken(c),
+		"Role":         account.AccountType,
+		"Statuses":     ReportStatuses,
+	})
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type retentionConfig struct {
+	DefaultExpiry time.Duration `toml:"default_expiry"` // Applied to uploads that don't specify an expiry
+	MaxExpiry     time.Duration `toml:"max_expiry"`     // Longest expiry an upload can have
+	ClampExpiry   bool          `toml:"clamp_expiry"`   // Clamps expiries over the maximum instead of rejecting the upload
+}
+
+var ErrRetentionExceeded = errors.New("expiry exceeds the maximum retention")
+
+// Returns the retention policy for the account, the shortest maximum of the global, role and account settings wins
+func (app *Application) retentionFor(account Accounts) (defaultExpiry time.Duration, maxExpiry time.Duration) {
+	maxExpiry = app.config.Retention.MaxExpiry
+	if accountMax := app.quotaFor(account).MaxRetention; accountMax > 0 && (maxExpiry == 0 || accountMax < maxExpiry) {
+		maxExpiry = accountMax
+	}
+
+	defaultExpiry = app.config.Retention.DefaultExpiry
+	if roleDefault := app.config.Roles[account.AccountType].DefaultExpiry; roleDefault > 0 {
+		defaultExpiry = roleDefault
+	}
+
+	// With a maximum set files can't live forever
+	if maxExpiry > 0 && (defaultExpiry == 0 || defaultExpiry > maxExpiry) {
+		defaultExpiry = maxExpiry
+	}
+
+	return
+}
+
+// Gives files without an expiry the default one and clamps or rejects expiries over the maximum
+func (app *Application) applyRetention(account Accounts, expiryDate time.Time) (time.Time, error) {
+	defaultExpiry, maxExpiry := app.retentionFor(account)
+	now := time.Now()
+
+	if expiryDate.IsZero() && defaultExpiry > 0 {
+		expiryDate = now.Add(defaultExpiry)
+	}
+
+	if maxExpiry > 0 && expiryDate.After(now.Add(maxExpiry)) {
+		if !app.config.Retention.ClampExpiry {
+			return expiryDate, ErrRetentionExceeded
+		}
+
+		expiryDate = now.Add(maxExpiry)
+	}
+
+	return expiryDate, nil
+}
+
+// Expiry an existing file should have under the current policy, measured from its upload time
+func retentionExpiry(file Files, defaultExpiry time.Duration, maxExpiry time.Duration) time.Time {
+	expiryDate := file.ExpiryDate
+
+	if expiryDate.IsZero() && defaultExpiry > 0 {
+		expiryDate = file.CreatedAt.Add(defaultExpiry)
+	}
+
+	if maxExpiry > 0 && (expiryDate.IsZero() || expiryDate.After(file.CreatedAt.Add(maxExpiry))) {
+		expiryDate = file.CreatedAt.Add(maxExpiry)
+	}
+
+	return expiryDate
+}
+
+// Applies the retention policy to already uploaded files, files that end up expired get deleted by the next clean up job
+func (app *Application) adminApplyRetentionPolicy(c *gin.Context) {
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	accounts, err := app.db.withContext(c).getAccounts()
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get accounts")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var updated int64
+	for _, account := range accounts {
+		defaultExpiry, maxExpiry := app.retentionFor(account)
+		if defaultExpiry == 0 && maxExpiry == 0 {
+			continue
+		}
+
+		files, err := app.db.withContext(c).getAllFilesFromAccount(account.ID)
+		if err != nil {
+			log.Ctx(c).Err(err).Msg("Failed to get files from account")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		expiries := make(map[uint]time.Time)
+		for _, file := range files {
+			if expiryDate := retentionExpiry(file, defaultExpiry, maxExpiry); !expiryDate.Equal(file.ExpiryDate) {
+				expiries[file.ID] = expiryDate
+			}
+		}
+
+		if err = app.db.withContext(c).setFileExpiries(expiries); err != nil {
+			log.Ctx(c).Err(err).Msg("Failed to update file expiries")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		updated += int64(len(expiries))
+	}
+
+	app.audit(c, actor.ID, AuditApplyRetention, noTarget, fmt.Sprintf("updated the expiry of %d files", updated))
+
+	c.String(http.StatusOK, fmt.Sprintf("Updated the expiry of %d files", updated))
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Stored in the account_type column
+type Role string
+
+const (
+	RoleUser      Role = "USER"      // Uploader, can only manage their own files
+	RoleModerator Role = "MODERATOR" // Can delete other users files but not the users themselves
+	RoleAuditor   Role = "AUDITOR"   // Read only access to the admin panel and the audit log
+	RoleAdmin     Role = "ADMIN"
+)
+
+var Roles = []Role{RoleUser, RoleModerator, RoleAuditor, RoleAdmin}
+
+type Permission string
+
+const (
+	PermUpload          Permission = "upload"
+	PermViewAdmin       Permission = "view_admin"
+	PermDeleteFiles     Permission = "delete_files"    // Deleting other users files
+	PermManageSessions  Permission = "manage_sessions" // Revoking other users sessions and upload tokens
+	PermDeleteUsers     Permission = "delete_users"
+	PermCreateInvites   Permission = "create_invites"
+	PermManageRoles     Permission = "manage_roles"
+	PermManageQuotas    Permission = "manage_quotas"
+	PermApplyRetention  Permission = "apply_retention"
+	PermViewAuditLog    Permission = "view_audit_log"
+	PermModerateFiles   Permission = "moderate_files" // Hiding, quarantining and changing the expiry of other users files
+	PermHandleReports   Permission = "handle_reports" // Viewing and resolving abuse reports
+	PermSuspendUsers    Permission = "suspend_users"
+	PermManageBlocklist Permission = "manage_blocklist" // Blocking content hashes from being uploaded
+	PermManageWebhooks  Permission = "manage_webhooks"  // Webhooks that get the events of every account
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser: {
+		PermUpload,
+	},
+	RoleModerator: {
+		PermUpload,
+		PermViewAdmin,
+		PermDeleteFiles,
+		PermModerateFiles,
+		PermHandleReports,
+		PermManageBlocklist,
+	},
+	RoleAuditor: {
+		PermUpload,
+		PermViewAdmin,
+		PermViewAuditLog,
+	},
+	RoleAdmin: {
+		PermUpload,
+		PermViewAdmin,
+		PermDeleteFiles,
+		PermManageSessions,
+		PermDeleteUsers,
+		PermCreateInvites,
+		PermManageRoles,
+		PermManageQuotas,
+		PermApplyRetention,
+		PermViewAuditLog,
+		PermModerateFiles,
+		PermHandleReports,
+		PermSuspendUsers,
+		PermManageBlocklist,
+		PermManageWebhooks,
+	},
+}
+
+var ErrInvalidRole = errors.New("invalid role")
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Used in templates
+func roleCan(role Role, permission Permission) bool {
+	return role.Can(permission)
+}
+
+// Makes sure the authenticated account has the permission
+func (app *Application) requirePermission(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		account, err := app.accountFromContext(c)
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, ErrNotAuthenticated) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Ctx(c).Err(err).Msg("Failed to find account for permission check")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if !account.AccountType.Can(permission) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
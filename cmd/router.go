@@ -12,7 +12,16 @@ import (
 )
 
 func setupRatelimiting(c Config) *limiter.Limiter {
-	rateLimiter := tollbooth.NewLimiter(2, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
+	return newRateLimiter(c, 2)
+}
+
+// Reports are rare, a handful per hour is plenty for anyone reporting in good faith
+func setupReportRatelimiting(c Config) *limiter.Limiter {
+	return newRateLimiter(c, 5.0/3600).SetBurst(5)
+}
+
+func newRateLimiter(c Config, perSecond float64) *limiter.Limiter {
+	rateLimiter := tollbooth.NewLimiter(perSecond, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
 
 	if c.BehindReverseProxy {
 		rateLimiter.SetIPLookup(limiter.IPLookup{
@@ -34,7 +43,8 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 	app = (*Application)(uninitializedApp)
 	log.Info().Msg("Setting up router")
 
-	app.Router = gin.Default()
+	app.Router = gin.New()
+	app.Router.ContextWithFallback = true // Lets log.Ctx(c) find the request logger
 	app.Router.ForwardedByClientIP = c.BehindReverseProxy
 	app.Router.SetTrustedProxies([]string{c.TrustedProxy})
 
@@ -45,6 +55,7 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 		"mimeIsImage":    mimeIsImage,
 		"mimeIsVideo":    mimeIsVideo,
 		"mimeIsAudio":    mimeIsAudio,
+		"can":            roleCan,
 	})
 
 	app.Router.SetHTMLTemplate(template.Must(template.
@@ -53,22 +64,39 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 		ParseFS(TemplateFiles, "templates/*.gohtml", "templates/components/*.gohtml"),
 	))
 
+	// Tracing adds no middleware or callbacks unless an endpoint is configured
+	if app.tracing = setupTracing(c.Tracing); app.tracing != nil {
+		app.Router.Use(app.tracingMiddleware())
+	}
+
 	app.Router.Use(
+		app.accessLogMiddleware(),
+		gin.Recovery(),
+		app.metricsMiddleware(),
 		app.bodySizeMiddleware(),
+		app.contentHostMiddleware(),
 	)
 
+	app.registerStateMetrics()
+	if c.Metrics.Enabled && c.Metrics.Listen == "" {
+		app.Router.GET("/metrics", gin.WrapH(app.metricsHandler()))
+	}
+
 	api := app.Router.Group("/api")
 	api.Use(app.apiMiddleware())
 
 	app.setupAuth(api)
 
+	api.POST("/report", app.limitMiddleware("report", setupReportRatelimiting(c)), app.reportFileAPI)
+
 	// Apis that require the upload token, typical this token is included in scripts
 	fileAPI := api.Group("/file")
 	fileAPI.Use(
 		app.hasUploadOrSessionTokenMiddleware(),
+		app.csrfMiddleware(),
 	)
 
-	fileAPI.POST("/upload", app.uploadFileAPI)
+	fileAPI.POST("/upload", app.requirePermission(PermUpload), app.uploadFileAPI)
 	fileAPI.POST("/delete", app.deleteFileAPI)
 	// ---
 
@@ -77,6 +105,7 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 	accountAPI.Use(
 		app.verifySessionAuthentication(),
 		app.isSessionAuthenticated(),
+		app.csrfMiddleware(),
 	)
 
 	accountAPI.POST("/delete", app.accountDeleteAPI)
@@ -87,20 +116,60 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 	accountAPI.POST("/toggle_file_public", app.toggleFilePublicAPI)
 	accountAPI.GET("/files", app.filesAPI)
 	accountAPI.GET("/file_stats", app.fileStatsAPI)
+	accountAPI.GET("/trash", app.trashAPI)
+	accountAPI.POST("/restore_file", app.restoreFileAPI)
+	accountAPI.GET("/webhooks", app.accountWebhooksAPI)
+	accountAPI.POST("/create_webhook", app.accountCreateWebhookAPI)
+	accountAPI.POST("/delete_webhook", app.accountDeleteWebhookAPI)
+	accountAPI.GET("/webhook_deliveries", app.accountWebhookDeliveriesAPI)
+	accountAPI.POST("/redeliver_webhook", app.accountRedeliverWebhookAPI)
+	accountAPI.GET("/export", app.accountExportAPI)
+	accountAPI.POST("/request_export", app.requestExportAPI)
+	accountAPI.GET("/download_export", app.downloadExportAPI)
+	accountAPI.POST("/download_files", app.downloadFilesAPI)
+	accountAPI.POST("/bulk_files", app.bulkFilesAPI)
 	// ---
 
 	// Admin apis
 	adminAPI := api.Group("/admin")
 	adminAPI.Use(
 		app.verifySessionAuthentication(),
-		app.isAdmin(),
+		app.requirePermission(PermViewAdmin),
+		app.csrfMiddleware(),
 	)
 
-	adminAPI.POST("/delete_user", app.adminDeleteUser)
-	adminAPI.POST("/delete_files", app.adminDeleteFiles)
-	adminAPI.POST("/delete_sessions", app.adminDeleteSessions)
-	adminAPI.POST("/delete_upload_tokens", app.adminDeleteUploadTokens)
-	adminAPI.POST("/give_invite_code", app.adminGiveInviteCode)
+	adminAPI.GET("/users", app.adminUsersAPI)
+	adminAPI.POST("/delete_user", app.requirePermission(PermDeleteUsers), app.adminDeleteUser)
+	adminAPI.POST("/delete_files", app.requirePermission(PermDeleteFiles), app.adminDeleteFiles)
+	adminAPI.POST("/delete_sessions", app.requirePermission(PermManageSessions), app.adminDeleteSessions)
+	adminAPI.POST("/delete_upload_tokens", app.requirePermission(PermManageSessions), app.adminDeleteUploadTokens)
+	adminAPI.POST("/give_invite_code", app.requirePermission(PermCreateInvites), app.adminGiveInviteCode)
+	adminAPI.POST("/suspend_user", app.requirePermission(PermSuspendUsers), app.adminSuspendUser)
+	adminAPI.POST("/unsuspend_user", app.requirePermission(PermSuspendUsers), app.adminUnsuspendUser)
+	adminAPI.POST("/set_role", app.requirePermission(PermManageRoles), app.adminSetRole)
+	adminAPI.POST("/set_quota", app.requirePermission(PermManageQuotas), app.adminSetQuota)
+	adminAPI.POST("/apply_retention_policy", app.requirePermission(PermApplyRetention), app.adminApplyRetentionPolicy)
+	adminAPI.GET("/files", app.requirePermission(PermModerateFiles), app.adminFilesAPI)
+	adminAPI.POST("/delete_file", app.requirePermission(PermDeleteFiles), app.adminDeleteFile)
+	adminAPI.POST("/hide_file", app.requirePermission(PermModerateFiles), app.adminHideFile)
+	adminAPI.POST("/quarantine_file", app.requirePermission(PermModerateFiles), app.adminQuarantineFile)
+	adminAPI.POST("/clear_file_moderation", app.requirePermission(PermModerateFiles), app.adminClearFileModeration)
+	adminAPI.POST("/set_file_expiry", app.requirePermission(PermModerateFiles), app.adminSetFileExpiry)
+	adminAPI.POST("/disable_file", app.requirePermission(PermModerateFiles), app.adminDisableFile)
+	adminAPI.GET("/reports", app.requirePermission(PermHandleReports), app.adminReportsAPI)
+	adminAPI.POST("/resolve_report", app.requirePermission(PermHandleReports), app.adminResolveReport)
+	adminAPI.GET("/blocked_hashes", app.requirePermission(PermManageBlocklist), app.adminBlockedHashesAPI)
+	adminAPI.POST("/block_hash", app.requirePermission(PermManageBlocklist), app.adminBlockHash)
+	adminAPI.POST("/block_file", app.requirePermission(PermManageBlocklist), app.adminBlockFile)
+	adminAPI.POST("/unblock_hash", app.requirePermission(PermManageBlocklist), app.adminUnblockHash)
+	adminAPI.POST("/import_blocked_hashes", app.requirePermission(PermManageBlocklist), app.adminImportBlockedHashes)
+	adminAPI.GET("/audit_log", app.requirePermission(PermViewAuditLog), app.adminAuditLogAPI)
+	adminAPI.GET("/audit_log/export", app.requirePermission(PermViewAuditLog), app.adminExportAuditLogAPI)
+	adminAPI.GET("/webhooks", app.requirePermission(PermManageWebhooks), app.adminWebhooksAPI)
+	adminAPI.POST("/create_webhook", app.requirePermission(PermManageWebhooks), app.adminCreateWebhookAPI)
+	adminAPI.POST("/delete_webhook", app.requirePermission(PermManageWebhooks), app.adminDeleteWebhookAPI)
+	adminAPI.GET("/webhook_deliveries", app.requirePermission(PermManageWebhooks), app.adminWebhookDeliveriesAPI)
+	adminAPI.POST("/redeliver_webhook", app.requirePermission(PermManageWebhooks), app.adminRedeliverWebhookAPI)
 
 	app.Router.StaticFS("/public/", PublicFiles())
 
@@ -109,7 +178,15 @@ func setupRouter(uninitializedApp *uninitializedApplication, c Config) (app *App
 	app.Router.GET("/logout", app.logoutHandler)
 	app.Router.GET("/user", app.userPage)
 	app.Router.GET("/admin", app.adminPage)
+	app.Router.GET("/admin/files", app.adminFilesPage)
+	app.Router.GET("/admin/reports", app.adminReportsPage)
+	app.Router.GET("/admin/blocklist", app.adminBlocklistPage)
+	app.Router.GET("/admin/webhooks", app.adminWebhooksPage)
+	app.Router.GET("/report", app.reportPage)
+	app.Router.GET("/view/:file", app.fileViewPage)
 	app.Router.GET("/", app.indexPage)
+	app.Router.GET("/healthz", app.healthzHandler)
+	app.Router.GET("/readyz", app.ratelimitMiddleware(), app.readyzHandler) // Registered before the Use below, so it needs its own limiter
 	app.Router.Use(app.ratelimitMiddleware())
 	app.Router.NoRoute(app.indexFiles)
 
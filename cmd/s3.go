@@ -2,14 +2,33 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"io"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-func (app *Application) uploadFileS3(file []byte, fileName string) (err error) {
-	_, err = app.s3client.PutObject(&s3.PutObjectInput{
-		Body:   bytes.NewReader(file),
+// Risky files also get their download disposition stored with the object in case the bucket is reached directly
+func (app *Application) uploadFileS3(ctx context.Context, file []byte, fileName string, contentType string, contentDisposition string) (err error) {
+	input := &s3.PutObjectInput{
+		Body:        bytes.NewReader(file),
+		Bucket:      aws.String(app.config.S3.Bucket),
+		Key:         aws.String(fileName),
+		ContentType: aws.String(contentType),
+	}
+
+	if contentDisposition != "" {
+		input.ContentDisposition = aws.String(contentDisposition)
+	}
+
+	_, err = app.s3client.PutObjectWithContext(ctx, input)
+
+	return
+}
+
+func (app *Application) deleteFileS3(ctx context.Context, fileName string) (err error) {
+	_, err = app.s3client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(app.config.S3.Bucket),
 		Key:    aws.String(fileName),
 	})
@@ -17,11 +36,27 @@ func (app *Application) uploadFileS3(file []byte, fileName string) (err error) {
 	return
 }
 
-func (app *Application) deleteFileS3(fileName string) (err error) {
-	_, err = app.s3client.DeleteObject(&s3.DeleteObjectInput{
+func (app *Application) readFileS3(ctx context.Context, fileName string) (file []byte, err error) {
+	object, err := app.s3client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(app.config.S3.Bucket),
 		Key:    aws.String(fileName),
 	})
+	if err != nil {
+		return
+	}
+	defer object.Body.Close()
 
-	return
+	return io.ReadAll(object.Body)
+}
+
+func (app *Application) openFileS3(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	object, err := app.s3client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(app.config.S3.Bucket),
+		Key:    aws.String(fileName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return object.Body, nil
 }
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type scanningConfig struct {
+	ClamdAddress string        `toml:"clamd_address"` // e.g "tcp://localhost:3310" or "unix:///run/clamav/clamd.ctl", empty disables scanning
+	Action       string        `toml:"action"`        // What to do with infected uploads, "reject" or "quarantine"
+	FailClosed   bool          `toml:"fail_closed"`   // Rejects uploads when the scanner can't be reached instead of storing them unscanned
+	Timeout      time.Duration `toml:"timeout"`
+}
+
+const (
+	scanActionReject     = "reject"
+	scanActionQuarantine = "quarantine"
+)
+
+// Stored in the scan_status column of files, empty means the file wasn't scanned
+const (
+	ScanClean    = "CLEAN"
+	ScanInfected = "INFECTED"
+	ScanFailed   = "ERROR" // Scanner couldn't be reached and fail_closed is off
+)
+
+type ScanResult struct {
+	Infected  bool
+	Signature string // Name of the detected malware
+}
+
+// Anything that can tell if content is malicious, clamd is the only implementation for now
+type FileScanner interface {
+	Scan(ctx context.Context, content io.Reader) (ScanResult, error)
+}
+
+func prepareScanner(c Config) FileScanner {
+	if c.Scanning.ClamdAddress == "" {
+		return nil
+	}
+
+	scanner, err := newClamdScanner(c.Scanning.ClamdAddress, c.Scanning.Timeout)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid clamd address")
+	}
+
+	log.Info().Msgf("Scanning uploads with clamd at %s", c.Scanning.ClamdAddress)
+
+	return scanner
+}
+
+// Talks to clamd with the INSTREAM command, see https://linux.die.net/man/8/clamd
+type clamdScanner struct {
+	network string // tcp or unix
+	address string
+	timeout time.Duration
+}
+
+var ErrInvalidClamdAddress = errors.New("clamd address has to start with tcp:// or unix://")
+
+func newClamdScanner(rawAddress string, timeout time.Duration) (*clamdScanner, error) {
+	address, err := url.Parse(rawAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := &clamdScanner{network: address.Scheme, timeout: timeout}
+	switch address.Scheme {
+	case "tcp":
+		scanner.address = address.Host
+	case "unix":
+		scanner.address = address.Path
+	default:
+		return nil, ErrInvalidClamdAddress
+	}
+
+	return scanner, nil
+}
+
+// clamd closes the stream once it goes over its StreamMaxLength, chunks are kept well below the default
+const clamdChunkSize = 64 * 1024
+
+var ErrClamdResponse = errors.New("unexpected clamd response")
+
+func (s *clamdScanner) Scan(ctx context.Context, content io.Reader) (result ScanResult, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return
+	}
+
+	// Every chunk is prefixed with its length, a zero length chunk ends the stream
+	chunk := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := content.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk[:4], uint32(n))
+			if _, err = conn.Write(chunk[:4+n]); err != nil {
+				return
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return result, readErr
+		}
+	}
+
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil {
+		return
+	}
+
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00")))
+}
+
+// Replies look like "stream: OK" or "stream: Eicar-Test-Signature FOUND"
+func parseClamdReply(reply string) (result ScanResult, err error) {
+	status, found := strings.CutPrefix(reply, "stream: ")
+	if !found {
+		return result, fmt.Errorf("%w: %q", ErrClamdResponse, reply)
+	}
+
+	if status == "OK" {
+		return
+	}
+
+	if signature, infected := strings.CutSuffix(status, " FOUND"); infected {
+		return ScanResult{Infected: true, Signature: signature}, nil
+	}
+
+	return result, fmt.Errorf("%w: %q", ErrClamdResponse, reply)
+}
+
+var ErrScannerUnavailable = errors.New("malware scanner unavailable")
+
+// Scans the upload if scanning is enabled, the returned status is stored on the file row
+func (app *Application) scanUpload(ctx context.Context, file []byte) (status string, result ScanResult, err error) {
+	if app.scanner == nil {
+		return
+	}
+
+	if result, err = app.scanner.Scan(ctx, bytes.NewReader(file)); err != nil {
+		log.Ctx(ctx).Err(err).Msg("Failed to scan upload")
+
+		if app.config.Scanning.FailClosed {
+			return status, result, ErrScannerUnavailable
+		}
+
+		return ScanFailed, result, nil
+	}
+
+	if result.Infected {
+		return ScanInfected, result, nil
+	}
+
+	return ScanClean, result, nil
+}
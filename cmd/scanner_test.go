@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Fake clamd that records what it was sent over INSTREAM and answers with reply, an empty reply never answers
+type fakeClamd struct {
+	listener net.Listener
+	reply    string
+
+	command chan string
+	chunks  chan []int
+	content chan []byte
+}
+
+func startFakeClamd(t *testing.T, network, address, reply string) *fakeClamd {
+	t.Helper()
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	clamd := &fakeClamd{
+		listener: listener,
+		reply:    reply,
+		command:  make(chan string, 1),
+		chunks:   make(chan []int, 1),
+		content:  make(chan []byte, 1),
+	}
+
+	go clamd.serve()
+
+	return clamd
+}
+
+func (f *fakeClamd) serve() {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return
+	}
+	f.command <- command
+
+	var chunks []int
+	var content bytes.Buffer
+	for {
+		var size uint32
+		if err = binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return
+		}
+
+		if size == 0 {
+			break
+		}
+
+		chunks = append(chunks, int(size))
+		if _, err = io.CopyN(&content, reader, int64(size)); err != nil {
+			return
+		}
+	}
+
+	f.chunks <- chunks
+	f.content <- content.Bytes()
+
+	if f.reply == "" {
+		// Holds the connection open until the scanner gives up
+		io.Copy(io.Discard, reader)
+		return
+	}
+
+	conn.Write([]byte(f.reply + "\x00"))
+}
+
+func (f *fakeClamd) scanner(t *testing.T, timeout time.Duration) *clamdScanner {
+	t.Helper()
+
+	address := f.listener.Addr()
+	scanner, err := newClamdScanner(address.Network()+"://"+address.String(), timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scanner
+}
+
+func TestClamdScannerStreamsInChunks(t *testing.T) {
+	clamd := startFakeClamd(t, "tcp", "127.0.0.1:0", "stream: OK")
+
+	content := bytes.Repeat([]byte("hostling"), (2*clamdChunkSize+1000)/8)
+	result, err := clamd.scanner(t, 5*time.Second).Scan(context.Background(), bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Infected {
+		t.Errorf("clean content reported as infected with %q", result.Signature)
+	}
+
+	if command := <-clamd.command; command != "zINSTREAM\x00" {
+		t.Errorf("sent command %q, want zINSTREAM", command)
+	}
+
+	chunks := <-clamd.chunks
+	if len(chunks) != 3 || chunks[0] != clamdChunkSize || chunks[1] != clamdChunkSize || chunks[2] != len(content)-2*clamdChunkSize {
+		t.Errorf("sent chunks of %v bytes for %d bytes of content", chunks, len(content))
+	}
+
+	if received := <-clamd.content; !bytes.Equal(received, content) {
+		t.Errorf("clamd received %d bytes that differ from the %d bytes scanned", len(received), len(content))
+	}
+}
+
+func TestClamdScannerEmptyContent(t *testing.T) {
+	clamd := startFakeClamd(t, "tcp", "127.0.0.1:0", "stream: OK")
+
+	if _, err := clamd.scanner(t, 5*time.Second).Scan(context.Background(), bytes.NewReader(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	<-clamd.command
+	if chunks := <-clamd.chunks; len(chunks) != 0 {
+		t.Errorf("sent chunks of %v bytes for empty content", chunks)
+	}
+}
+
+func TestClamdScannerFound(t *testing.T) {
+	clamd := startFakeClamd(t, "tcp", "127.0.0.1:0", "stream: Eicar-Test-Signature FOUND")
+
+	result, err := clamd.scanner(t, 5*time.Second).Scan(context.Background(), bytes.NewReader([]byte("eicar")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Infected || result.Signature != "Eicar-Test-Signature" {
+		t.Errorf("got %+v, want an infection with Eicar-Test-Signature", result)
+	}
+}
+
+func TestClamdScannerErrorReply(t *testing.T) {
+	clamd := startFakeClamd(t, "tcp", "127.0.0.1:0", "INSTREAM size limit exceeded. ERROR")
+
+	_, err := clamd.scanner(t, 5*time.Second).Scan(context.Background(), bytes.NewReader([]byte("content")))
+	if !errors.Is(err, ErrClamdResponse) {
+		t.Errorf("got error %v, want ErrClamdResponse", err)
+	}
+}
+
+func TestClamdScannerTimeout(t *testing.T) {
+	clamd := startFakeClamd(t, "tcp", "127.0.0.1:0", "")
+
+	start := time.Now()
+	_, err := clamd.scanner(t, 200*time.Millisecond).Scan(context.Background(), bytes.NewReader([]byte("content")))
+	if err == nil {
+		t.Fatal("scan of a clamd that never answers succeeded")
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("scan gave up after %s, the timeout is 200ms", elapsed)
+	}
+}
+
+func TestClamdScannerUnixSocket(t *testing.T) {
+	clamd := startFakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"), "stream: OK")
+
+	scanner, err := newClamdScanner("unix://"+clamd.listener.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = scanner.Scan(context.Background(), bytes.NewReader([]byte("content"))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewClamdScanner(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		target  string
+		err     error
+	}{
+		{"tcp://localhost:3310", "tcp", "localhost:3310", nil},
+		{"unix:///run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl", nil},
+		{"localhost:3310", "", "", ErrInvalidClamdAddress},
+		{"http://localhost:3310", "", "", ErrInvalidClamdAddress},
+	}
+
+	for _, test := range tests {
+		scanner, err := newClamdScanner(test.address, time.Second)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%q: got error %v, want %v", test.address, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", test.address, err)
+			continue
+		}
+
+		if scanner.network != test.network || scanner.address != test.target {
+			t.Errorf("%q: got %s %s, want %s %s", test.address, scanner.network, scanner.address, test.network, test.target)
+		}
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply  string
+		result ScanResult
+		err    error
+	}{
+		{"stream: OK", ScanResult{}, nil},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND", ScanResult{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}, nil},
+		{"stream: Can't allocate memory ERROR", ScanResult{}, ErrClamdResponse},
+		{"INSTREAM size limit exceeded. ERROR", ScanResult{}, ErrClamdResponse},
+		{"", ScanResult{}, ErrClamdResponse},
+	}
+
+	for _, test := range tests {
+		result, err := parseClamdReply(test.reply)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q: got error %v, want %v", test.reply, err, test.err)
+		}
+
+		if result != test.result {
+			t.Errorf("%q: got %+v, want %+v", test.reply, result, test.result)
+		}
+	}
+}
+
+// Address nothing listens on, so scans fail right away
+func unreachableClamd(t *testing.T) *clamdScanner {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	scanner, err := newClamdScanner("tcp://"+address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scanner
+}
+
+func TestScanUploadFailClosed(t *testing.T) {
+	app := &Application{scanner: unreachableClamd(t)}
+	app.config.Scanning.FailClosed = true
+
+	if _, _, err := app.scanUpload(context.Background(), []byte("content")); !errors.Is(err, ErrScannerUnavailable) {
+		t.Errorf("got error %v, want ErrScannerUnavailable", err)
+	}
+}
+
+func TestScanUploadFailOpen(t *testing.T) {
+	app := &Application{scanner: unreachableClamd(t)}
+
+	status, _, err := app.scanUpload(context.Background(), []byte("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status != ScanFailed {
+		t.Errorf("got status %q, want %q", status, ScanFailed)
+	}
+}
+
+func TestScanUploadStatus(t *testing.T) {
+	tests := []struct {
+		reply  string
+		status string
+	}{
+		{"stream: OK", ScanClean},
+		{"stream: Eicar-Test-Signature FOUND", ScanInfected},
+	}
+
+	for _, test := range tests {
+		app := &Application{scanner: startFakeClamd(t, "tcp", "127.0.0.1:0", test.reply).scanner(t, 5*time.Second)}
+
+		status, _, err := app.scanUpload(context.Background(), []byte("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status != test.status {
+			t.Errorf("%q: got status %q, want %q", test.reply, status, test.status)
+		}
+	}
+
+	if status, _, err := (&Application{}).scanUpload(context.Background(), []byte("content")); status != "" || err != nil {
+		t.Errorf("scanning disabled: got status %q and error %v, want nothing", status, err)
+	}
+}
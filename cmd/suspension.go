@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Suspensions with an end date run out on their own, no job is needed to lift them
+func (a Accounts) Suspended() bool {
+	if a.SuspendedAt.IsZero() {
+		return false
+	}
+
+	return a.SuspendedUntil.IsZero() || time.Now().Before(a.SuspendedUntil)
+}
+
+// Whether public files of the account should be hidden right now
+func (a Accounts) FilesHidden() bool {
+	return a.Suspended() && a.SuspensionHidesFiles
+}
+
+// Shown to the suspended user when they try to log in
+func (a Accounts) suspensionMessage() string {
+	message := "Your account is suspended: " + a.SuspensionReason
+	if !a.SuspendedUntil.IsZero() {
+		message += fmt.Sprintf(" (until %s)", formatTimeDate(a.SuspendedUntil))
+	}
+
+	return message
+}
+
+var (
+	ErrCantSuspendSelf = errors.New("you can't suspend yourself")
+	ErrSuspendedUntil  = errors.New("suspension end date has to be in the future")
+)
+
+// Logs the account out everywhere, upload tokens are rejected while the suspension lasts
+func (app *Application) suspendAccount(ctx context.Context, accountID uint, reason string, until time.Time, hideFiles bool) (err error) {
+	if err = app.db.withContext(ctx).suspendAccount(accountID, reason, until, hideFiles); err != nil {
+		return
+	}
+
+	return app.db.withContext(ctx).deleteSessionTokensFromAccount(accountID)
+}
+
+type adminSuspendUserInput struct {
+	ID        uint   `form:"id"`
+	Reason    string `form:"reason"`
+	Until     string `form:"until"` // YYYY-MM-DD, empty suspends until unsuspended
+	HideFiles bool   `form:"hide_files"`
+}
+
+// Admin api for suspending an account without deleting anything
+func (app *Application) adminSuspendUser(c *gin.Context) {
+	var input adminSuspendUserInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if input.Reason == "" {
+		c.AbortWithError(http.StatusBadRequest, ErrReasonRequired)
+		return
+	}
+
+	var until time.Time
+	if input.Until != "" {
+		var err error
+		if until, err = time.Parse("2006-01-02", input.Until); err != nil {
+			c.String(http.StatusBadRequest, "Invalid end date")
+			return
+		}
+
+		if until.Before(time.Now()) {
+			c.AbortWithError(http.StatusBadRequest, ErrSuspendedUntil)
+			return
+		}
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if actor.ID == input.ID {
+		c.AbortWithError(http.StatusBadRequest, ErrCantSuspendSelf)
+		return
+	}
+
+	if _, err = app.db.withContext(c).getAccountByID(input.ID); errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find account to suspend")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err = app.suspendAccount(c, input.ID, input.Reason, until, input.HideFiles); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to suspend account")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	details := input.Reason
+	if !until.IsZero() {
+		details += fmt.Sprintf(" (until %s)", input.Until)
+	}
+	if input.HideFiles {
+		details += ", files hidden"
+	}
+
+	app.audit(c, actor.ID, AuditSuspendUser, accountTarget(input.ID), details)
+
+	c.String(http.StatusOK, fmt.Sprintf("User %d suspended", input.ID))
+}
+
+type adminUnsuspendUserInput struct {
+	ID uint `form:"id"`
+}
+
+// Admin api for lifting a suspension, the account and its files are back as they were
+func (app *Application) adminUnsuspendUser(c *gin.Context) {
+	var input adminUnsuspendUserInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	actor, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = app.db.withContext(c).unsuspendAccount(input.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to unsuspend account")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, actor.ID, AuditUnsuspendUser, accountTarget(input.ID), "")
+
+	c.String(http.StatusOK, fmt.Sprintf("User %d unsuspended", input.ID))
+}
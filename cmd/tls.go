@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Serving https directly, for setups without a reverse proxy.
+// Either cert_file and key_file or acme has to be set.
+type tlsConfig struct {
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+
+	Acme          bool   `toml:"acme"` // Automatic certificates for the public_url and content_url hosts, cached in data_folder
+	AcmeEmail     string `toml:"acme_email"`
+	AcmeDirectory string `toml:"acme_directory"` // Defaults to Let's Encrypt, can point to a test server like Pebble
+	AcmeCAFile    string `toml:"acme_ca_file"`   // Extra root certificate to trust for acme_directory
+
+	RedirectListen string `toml:"redirect_listen"` // Address of the plain http listener redirecting to https e.g ":80", also answers acme challenges
+}
+
+func (c tlsConfig) Enabled() bool {
+	return c.Acme || c.CertFile != ""
+}
+
+var (
+	ErrAcmeWithCertFile    = errors.New("tls.acme can't be used together with tls.cert_file and tls.key_file")
+	ErrIncompleteCertFiles = errors.New("tls.cert_file and tls.key_file have to be set together")
+	ErrRedirectWithoutTLS  = errors.New("tls.redirect_listen needs tls to be enabled")
+)
+
+func (c tlsConfig) validate() error {
+	switch {
+	case c.Acme && (c.CertFile != "" || c.KeyFile != ""):
+		return ErrAcmeWithCertFile
+	case (c.CertFile == "") != (c.KeyFile == ""):
+		return ErrIncompleteCertFiles
+	case c.RedirectListen != "" && !c.Enabled():
+		return ErrRedirectWithoutTLS
+	}
+
+	return nil
+}
+
+func (c *Config) validateTLS() {
+	if err := c.TLS.validate(); err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+}
+
+// Returns nil when tls is disabled
+func (app *Application) serverTLSConfig() (*tls.Config, *autocert.Manager) {
+	if !app.config.TLS.Enabled() {
+		return nil, nil
+	}
+
+	if !app.config.TLS.Acme {
+		config, err := certFileTLSConfig(app.config.TLS.CertFile, app.config.TLS.KeyFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load tls certificate")
+		}
+
+		return config, nil
+	}
+
+	hosts := []string{hostname(app.config.PublicUrl)}
+	if app.config.ContentUrl != "" {
+		hosts = append(hosts, hostname(app.config.ContentUrl))
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(filepath.Join(app.config.DataFolder, "certs")),
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      app.config.TLS.AcmeEmail,
+	}
+
+	if app.config.TLS.AcmeDirectory != "" {
+		manager.Client = &acme.Client{
+			DirectoryURL: app.config.TLS.AcmeDirectory,
+			HTTPClient:   app.acmeHTTPClient(),
+		}
+	}
+
+	log.Info().Strs("hosts", hosts).Msg("Requesting tls certificates with acme")
+
+	return manager.TLSConfig(), manager
+}
+
+func certFileTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}, nil
+}
+
+func (app *Application) acmeHTTPClient() *http.Client {
+	if app.config.TLS.AcmeCAFile == "" {
+		return http.DefaultClient
+	}
+
+	rootCA, err := os.ReadFile(app.config.TLS.AcmeCAFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to read acme_ca_file")
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(rootCA) {
+		log.Fatal().Msg("No certificates found in acme_ca_file")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &http.Client{Transport: transport}
+}
+
+// Plain http listener sending everything to https, acme http-01 challenges are answered before redirecting
+func (app *Application) redirectServer(manager *autocert.Manager) *http.Server {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+		if app.config.Port != "443" {
+			host = net.JoinHostPort(host, app.config.Port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 addresses need brackets in urls
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	if manager != nil {
+		handler = manager.HTTPHandler(handler)
+	}
+
+	log.Info().Msgf("Redirecting http at %s to https", app.config.TLS.RedirectListen)
+
+	return &http.Server{
+		Addr:    app.config.TLS.RedirectListen,
+		Handler: handler,
+	}
+}
+
+func hostname(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Invalid url %q", rawURL)
+	}
+
+	return parsed.Hostname()
+}
@@ -0,0 +1,98 @@
+//go:build pebble
+
+package cmd
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Issues a certificate from a local Pebble server, run with
+//
+//	pebble -config test/config/pebble-config.json
+//	go test -tags pebble -run Pebble ./cmd
+//
+// PEBBLE_DIRECTORY, PEBBLE_CA_FILE and PEBBLE_HOST override the defaults, the host has to resolve to this machine.
+// Pebble validates http-01 challenges on port 5002, where the redirect server is started.
+func TestAcmePebble(t *testing.T) {
+	dataFolder := t.TempDir()
+	host := pebbleEnv("PEBBLE_HOST", "localhost")
+
+	app := &Application{}
+	app.config.DataFolder = dataFolder
+	app.config.PublicUrl = "https://" + host
+	app.config.Port = "5001"
+	app.config.TLS = tlsConfig{
+		Acme:           true,
+		AcmeDirectory:  pebbleEnv("PEBBLE_DIRECTORY", "https://localhost:14000/dir"),
+		AcmeCAFile:     pebbleEnv("PEBBLE_CA_FILE", "pebble.minica.pem"),
+		RedirectListen: ":5002",
+	}
+
+	_, manager := app.serverTLSConfig()
+
+	listener, err := net.Listen("tcp", app.config.TLS.RedirectListen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redirect := app.redirectServer(manager)
+	go redirect.Serve(listener)
+	t.Cleanup(func() { redirect.Close() })
+
+	hello := &tls.ClientHelloInfo{ServerName: host, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
+
+	certificate, err := manager.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = certificate.Leaf.VerifyHostname(host); err != nil {
+		t.Errorf("issued certificate isn't valid for %s: %v", host, err)
+	}
+
+	if certificate.Leaf.NotAfter.Before(time.Now()) {
+		t.Error("issued certificate has already expired")
+	}
+
+	cached, err := os.ReadDir(filepath.Join(dataFolder, "certs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, entry := range cached {
+		if entry.Name() == host || entry.Name() == host+"+rsa" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("certificate for %s wasn't cached in data_folder/certs, found %v", host, cached)
+	}
+
+	// A restarted server has to load the certificate from the cache instead of requesting a new one
+	redirect.Close()
+	_, restarted := app.serverTLSConfig()
+
+	reloaded, err := restarted.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reloaded.Leaf.Equal(certificate.Leaf) {
+		t.Error("restarted server requested a new certificate instead of using the cached one")
+	}
+}
+
+func pebbleEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestTLSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config tlsConfig
+		err    error
+	}{
+		{"disabled", tlsConfig{}, nil},
+		{"cert files", tlsConfig{CertFile: "cert.pem", KeyFile: "key.pem"}, nil},
+		{"acme", tlsConfig{Acme: true}, nil},
+		{"acme with redirect", tlsConfig{Acme: true, RedirectListen: ":80"}, nil},
+		{"acme and cert files", tlsConfig{Acme: true, CertFile: "cert.pem", KeyFile: "key.pem"}, ErrAcmeWithCertFile},
+		{"acme and key file", tlsConfig{Acme: true, KeyFile: "key.pem"}, ErrAcmeWithCertFile},
+		{"only cert file", tlsConfig{CertFile: "cert.pem"}, ErrIncompleteCertFiles},
+		{"only key file", tlsConfig{KeyFile: "key.pem"}, ErrIncompleteCertFiles},
+		{"redirect without tls", tlsConfig{RedirectListen: ":80"}, ErrRedirectWithoutTLS},
+	}
+
+	for _, test := range tests {
+		if err := test.config.validate(); !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestRedirectServer(t *testing.T) {
+	tests := []struct {
+		port     string
+		host     string
+		target   string
+		location string
+	}{
+		{"443", "example.com", "/file.png?x=1", "https://example.com/file.png?x=1"},
+		{"443", "example.com:80", "/", "https://example.com/"},
+		{"8443", "example.com", "/user", "https://example.com:8443/user"},
+		{"8443", "example.com:8080", "/user", "https://example.com:8443/user"},
+		{"443", "[::1]:80", "/", "https://[::1]/"},
+		{"443", "[::1]", "/", "https://[::1]/"},
+		{"8443", "[::1]:80", "/", "https://[::1]:8443/"},
+	}
+
+	for _, test := range tests {
+		app := &Application{}
+		app.config.Port = test.port
+		app.config.TLS.RedirectListen = ":80"
+
+		request := httptest.NewRequest(http.MethodGet, test.target, nil)
+		request.Host = test.host
+
+		recorder := httptest.NewRecorder()
+		app.redirectServer(nil).Handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusMovedPermanently {
+			t.Errorf("%s on port %s: got status %d, want %d", test.host, test.port, recorder.Code, http.StatusMovedPermanently)
+		}
+
+		if location := recorder.Header().Get("Location"); location != test.location {
+			t.Errorf("%s%s on port %s: redirected to %q, want %q", test.host, test.target, test.port, location, test.location)
+		}
+	}
+}
+
+// Acme challenges have to be answered by the redirect listener instead of being redirected
+func TestRedirectServerAnswersAcmeChallenges(t *testing.T) {
+	app := &Application{}
+	app.config.Port = "443"
+
+	manager := &autocert.Manager{Prompt: autocert.AcceptTOS, HostPolicy: autocert.HostWhitelist("example.com")}
+
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/unknown-token", nil)
+	request.Host = "example.com"
+
+	recorder := httptest.NewRecorder()
+	app.redirectServer(manager).Handler.ServeHTTP(recorder, request)
+
+	// No challenge is pending, so the manager answers 404 itself
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown challenge, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+// Writes a self signed certificate and its key to dir
+func writeTestCertificate(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKey}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestServerTLSConfigCertFiles(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir(), "server")
+
+	app := &Application{}
+	app.config.TLS = tlsConfig{CertFile: certFile, KeyFile: keyFile}
+
+	config, manager := app.serverTLSConfig()
+	if manager != nil {
+		t.Error("got an acme manager for certificate files")
+	}
+
+	if config == nil || len(config.Certificates) != 1 {
+		t.Fatalf("got %+v, want a config with one certificate", config)
+	}
+
+	leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if leaf.Subject.CommonName != "example.com" {
+		t.Errorf("loaded certificate for %q, want example.com", leaf.Subject.CommonName)
+	}
+}
+
+func TestCertFileTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _ := writeTestCertificate(t, dir, "first")
+	_, otherKeyFile := writeTestCertificate(t, dir, "second")
+
+	if _, err := certFileTLSConfig(certFile, otherKeyFile); err == nil {
+		t.Error("loaded a certificate with a key that doesn't belong to it")
+	}
+
+	if _, err := certFileTLSConfig(filepath.Join(dir, "missing.crt"), otherKeyFile); err == nil {
+		t.Error("loaded a certificate file that doesn't exist")
+	}
+}
+
+func TestServerTLSConfigDisabled(t *testing.T) {
+	if config, manager := (&Application{}).serverTLSConfig(); config != nil || manager != nil {
+		t.Error("got a tls config with tls disabled")
+	}
+}
+
+func TestServerTLSConfigAcme(t *testing.T) {
+	dataFolder := t.TempDir()
+
+	app := &Application{}
+	app.config.DataFolder = dataFolder
+	app.config.PublicUrl = "https://hostling.example.com"
+	app.config.ContentUrl = "https://usercontent.example.com"
+	app.config.TLS = tlsConfig{Acme: true, AcmeEmail: "admin@example.com"}
+
+	config, manager := app.serverTLSConfig()
+	if config == nil || manager == nil {
+		t.Fatal("got no tls config or acme manager with acme enabled")
+	}
+
+	if cache, ok := manager.Cache.(autocert.DirCache); !ok || string(cache) != filepath.Join(dataFolder, "certs") {
+		t.Errorf("certificates are cached in %v, want %s", manager.Cache, filepath.Join(dataFolder, "certs"))
+	}
+
+	for _, host := range []string{"hostling.example.com", "usercontent.example.com"} {
+		if err := manager.HostPolicy(context.Background(), host); err != nil {
+			t.Errorf("%s isn't allowed: %v", host, err)
+		}
+	}
+
+	if err := manager.HostPolicy(context.Background(), "attacker.example.com"); err == nil {
+		t.Error("certificates can be requested for hosts outside the config")
+	}
+
+	if manager.Email != "admin@example.com" {
+		t.Errorf("got email %q, want admin@example.com", manager.Email)
+	}
+}
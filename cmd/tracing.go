@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type tracingConfig struct {
+	OtlpEndpoint string  `toml:"otlp_endpoint"` // OTLP/HTTP collector e.g "http://localhost:4318", empty disables tracing
+	ServiceName  string  `toml:"service_name"`  // Defaults to hostling
+	SampleRatio  float64 `toml:"sample_ratio"`  // Share of requests traced, defaults to every request
+}
+
+func (c tracingConfig) Enabled() bool {
+	return c.OtlpEndpoint != ""
+}
+
+// Until setupTracing installs a provider this hands out no-op spans
+var tracer = otel.Tracer("github.com/BatteredBunny/hostling")
+
+// Installs the global tracer provider, returns nil when tracing is disabled
+func setupTracing(c tracingConfig) *sdktrace.TracerProvider {
+	if !c.Enabled() {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(c.OtlpEndpoint))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create otlp exporter")
+	}
+
+	if c.ServiceName == "" {
+		c.ServiceName = "hostling"
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if c.SampleRatio > 0 && c.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(c.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", c.ServiceName),
+			attribute.String("service.version", Version),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	log.Info().Str("endpoint", c.OtlpEndpoint).Msg("Exporting traces")
+
+	return provider
+}
+
+// Continues traces from incoming traceparent headers, the span ends up in the request context so log.Ctx and later spans can use it
+func (app *Application) tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		group := routeGroup(c)
+		name := c.Request.Method + " " + c.FullPath()
+		if c.FullPath() == "" {
+			name = c.Request.Method + " file"
+		}
+
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", c.FullPath()),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("hostling.route_group", group),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, c.Errors.String())
+		}
+	}
+}
+
+func startStorageSpan(ctx context.Context, operation string, backend fileStorageMethod, fileName string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "storage "+operation, trace.WithAttributes(
+		attribute.String("hostling.storage.backend", storageBackendLabel(backend)),
+		attribute.String("hostling.file_name", fileName),
+	))
+}
+
+// Ends the span and marks it failed if err is set, meant to be deferred
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+
+	span.End()
+}
+
+const dbTracingSpanKey = "hostling:tracing_span"
+
+// Creates a span for queries made with the request context through withContext, queries of background jobs
+// have no span to hang under and are left out instead of each starting a trace of their own
+func registerDBTracing(db *gorm.DB) error {
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx := tx.Statement.Context
+			if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+				return
+			}
+
+			_, span := tracer.Start(ctx, "db "+operation, trace.WithSpanKind(trace.SpanKindClient))
+			tx.InstanceSet(dbTracingSpanKey, span)
+		}
+	}
+
+	after := func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(dbTracingSpanKey)
+		if !ok {
+			return
+		}
+
+		span := value.(trace.Span)
+		span.SetAttributes(
+			attribute.String("db.system.name", tx.Dialector.Name()),
+			attribute.String("db.collection.name", tx.Statement.Table),
+			attribute.String("db.query.text", tx.Statement.SQL.String()),
+			attribute.Int64("db.response.returned_rows", tx.Statement.RowsAffected),
+		)
+
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			span.RecordError(tx.Error)
+			span.SetStatus(codes.Error, tx.Error.Error())
+		}
+
+		span.End()
+	}
+
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register("tracing:before_create", before("create")),
+		callbacks.Create().After("*").Register("tracing:after_create", after),
+		callbacks.Query().Before("*").Register("tracing:before_query", before("query")),
+		callbacks.Query().After("*").Register("tracing:after_query", after),
+		callbacks.Update().Before("*").Register("tracing:before_update", before("update")),
+		callbacks.Update().After("*").Register("tracing:after_update", after),
+		callbacks.Delete().Before("*").Register("tracing:before_delete", before("delete")),
+		callbacks.Delete().After("*").Register("tracing:after_delete", after),
+		callbacks.Row().Before("*").Register("tracing:before_row", before("row")),
+		callbacks.Row().After("*").Register("tracing:after_row", after),
+		callbacks.Raw().Before("*").Register("tracing:before_raw", before("raw")),
+		callbacks.Raw().After("*").Register("tracing:after_raw", after),
+	)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Deleted files are kept in trash for the configured retention before CleanUpJob purges them
+func (app *Application) trashCutoff() time.Time {
+	return time.Now().Add(-app.config.TrashRetention)
+}
+
+type TrashedFile struct {
+	FileName         string
+	OriginalFileName string
+	FileSize         uint
+	MimeType         string
+	DeletedAt        time.Time
+	PurgeAt          time.Time // When the file will be permanently deleted
+}
+
+// Api for listing files in your trash
+func (app *Application) trashAPI(c *gin.Context) {
+	sessionToken, exists := c.Get("sessionToken")
+	if !exists {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	files, err := app.db.withContext(c).getTrashedFiles(account.ID, app.trashCutoff())
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get trashed files")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	output := make([]TrashedFile, 0, len(files))
+	for _, file := range files {
+		output = append(output, TrashedFile{
+			FileName:         file.FileName,
+			OriginalFileName: file.OriginalFileName,
+			FileSize:         file.FileSize,
+			MimeType:         file.MimeType,
+			DeletedAt:        file.DeletedAt.Time,
+			PurgeAt:          file.DeletedAt.Time.Add(app.config.TrashRetention),
+		})
+	}
+
+	c.JSON(http.StatusOK, output)
+}
+
+// Api for restoring a file from your trash
+type restoreFileAPIInput struct {
+	FileName string `form:"file_name"`
+}
+
+func (app *Application) restoreFileAPI(c *gin.Context) {
+	var input restoreFileAPIInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if input.FileName == "" {
+		c.String(http.StatusBadRequest, "File name is required")
+		c.Abort()
+		return
+	}
+
+	sessionToken, exists := c.Get("sessionToken")
+	if !exists {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	account, err := app.db.withContext(c).getAccountBySessionToken(sessionToken.(string))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to fetch user by session token")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	file, err := app.db.withContext(c).getTrashedFile(input.FileName, account.ID, app.trashCutoff())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "File not found in trash")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find trashed file")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// Trashed files don't count towards the quota so restoring has to fit
+	if err = app.checkQuota(c, account, file.FileSize); errors.Is(err, ErrFileLargerThanQuota) || errors.Is(err, ErrStorageQuotaExceeded) || errors.Is(err, ErrFileQuotaExceeded) {
+		c.String(http.StatusInsufficientStorage, "Restoring this file would exceed your quota")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to check quota")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// Checked again while restoring, in case other uploads used up the quota in the meantime
+	if err = app.db.withContext(c).restoreFile(file, app.quotaFor(account)); errors.Is(err, ErrStorageQuotaExceeded) || errors.Is(err, ErrFileQuotaExceeded) {
+		c.String(http.StatusInsufficientStorage, "Restoring this file would exceed your quota")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to restore file")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, account.ID, AuditRestoreFile, fileTarget(file.FileName), "")
+
+	c.String(http.StatusOK, "File restored")
+}
@@ -1,6 +1,11 @@
 package cmd
 
 import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"strings"
 	"time"
 
@@ -8,8 +13,36 @@ import (
 	"github.com/google/uuid"
 )
 
-func parseToken(rawToken string) (uuid.UUID, error) {
-	return uuid.Parse(rawToken)
+// Recognizable prefixes so secret scanners can detect leaked tokens
+const (
+	sessionTokenPrefix = "hostling_session_"
+	uploadTokenPrefix  = "hostling_upload_"
+)
+
+var ErrInvalidToken = errors.New("invalid token format")
+
+func generateToken(prefix string) string {
+	return prefix + rand.Text()
+}
+
+// Tokens are only stored as hashes, they are random enough that a plain sha256 is fine
+func hashToken(rawToken string) string {
+	h := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(h[:])
+}
+
+// Validates the token format, tokens from before prefixes were added are uuids
+func parseToken(rawToken string) (string, error) {
+	if strings.HasPrefix(rawToken, sessionTokenPrefix) || strings.HasPrefix(rawToken, uploadTokenPrefix) {
+		return rawToken, nil
+	}
+
+	legacyToken, err := uuid.Parse(rawToken)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	return legacyToken.String(), nil
 }
 
 func formatTimeDate(t time.Time) string {
@@ -49,3 +82,10 @@ func Sum[T any](slice []T, getValue func(T) int) int {
 	}
 	return sum
 }
+
+// Only used to count each viewer of a file once, anything that ends up in front of people uses the keyed app.ipHash
+func hashIP(ip string) string {
+	h := sha1.New()
+	h.Write([]byte(ip))
+	return hex.EncodeToString(h.Sum(nil))
+}
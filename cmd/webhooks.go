@@ -0,0 +1,657 @@
+package cmd
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type webhooksConfig struct {
+	AllowPrivateNetworks bool          `toml:"allow_private_networks"` // Lets webhooks reach loopback and private addresses, off so users can't probe the internal network
+	Timeout              time.Duration `toml:"timeout"`                // How long a receiver gets to answer, defaults to 10 seconds
+	MaxAttempts          uint          `toml:"max_attempts"`           // Deliveries are given up after this many failures, defaults to 10
+}
+
+type WebhookEvent string
+
+const (
+	WebhookFileUploaded      WebhookEvent = "file.uploaded"
+	WebhookFileDeleted       WebhookEvent = "file.deleted" // Moved to the trash by the uploader
+	WebhookFileExpired       WebhookEvent = "file.expired" // Purged by CleanUpJob once the expiry date passed
+	WebhookAccountRegistered WebhookEvent = "account.registered"
+)
+
+var WebhookEvents = []WebhookEvent{WebhookFileUploaded, WebhookFileDeleted, WebhookFileExpired, WebhookAccountRegistered}
+
+// Events about other accounts only go to admin webhooks
+var adminWebhookEvents = []WebhookEvent{WebhookAccountRegistered}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "PENDING" // Waiting for its first or next attempt
+	DeliveryDelivered DeliveryStatus = "DELIVERED"
+	DeliveryFailed    DeliveryStatus = "FAILED" // Ran out of attempts
+)
+
+const (
+	maxWebhooksPerAccount = 10
+	webhookRetryBase      = 30 * time.Second
+	webhookRetryMax       = 6 * time.Hour
+	webhookDeliveryBatch  = 50
+	webhookLogRetention   = 30 * 24 * time.Hour // Finished deliveries are kept this long for the delivery log
+)
+
+var (
+	ErrWebhookAddressNotAllowed = errors.New("webhook address is in a private network")
+	ErrWebhookStatus            = errors.New("webhook receiver answered with an error status")
+)
+
+type Webhooks struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	AccountID uint `gorm:"index"` // Account that created the webhook
+	Admin     bool `gorm:"index"` // Admin webhooks get the events of every account
+
+	Url    string
+	Events string // Comma separated WebhookEvents
+	Secret string `json:"-"` // Signs the payloads, only shown when the webhook is created
+}
+
+func (w Webhooks) subscribed(event WebhookEvent) bool {
+	return slices.Contains(strings.Split(w.Events, ","), string(event))
+}
+
+// Each webhook gets its own delivery of an event, the payload is stored so retries send the exact same body
+type WebhookDeliveries struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+
+	WebhookID uint `gorm:"index"`
+	EventID   string
+	Event     WebhookEvent
+	Payload   string
+
+	Status        DeliveryStatus `gorm:"index:,composite:queue;not null;default:'PENDING'"`
+	NextAttemptAt time.Time      `gorm:"index:,composite:queue"`
+	Attempts      uint
+
+	ResponseStatus int       // Last http status, 0 when no response came back
+	LastError      string    // Why the last attempt failed
+	DeliveredAt    time.Time `gorm:"default:null"`
+}
+
+type webhookPayload struct {
+	ID        string       `json:"id"` // Same for every webhook receiving the event, receivers can use it to drop duplicates
+	Event     WebhookEvent `json:"event"`
+	CreatedAt time.Time    `json:"created_at"`
+	Data      any          `json:"data"`
+}
+
+type webhookFileData struct {
+	FileName         string    `json:"file_name"`
+	OriginalFileName string    `json:"original_file_name"`
+	FileSize         uint      `json:"file_size"`
+	MimeType         string    `json:"mime_type"`
+	Public           bool      `json:"public"`
+	Url              string    `json:"url"`
+	UploaderID       uint      `json:"uploader_id"`
+	ExpiryDate       time.Time `json:"expiry_date,omitzero"`
+}
+
+type webhookAccountData struct {
+	ID        uint `json:"id"`
+	Role      Role `json:"role"`
+	InvitedBy uint `json:"invited_by"`
+}
+
+func webhookTarget(id uint) auditTarget {
+	return auditTarget{Type: "webhook", ID: strconv.FormatUint(uint64(id), 10)}
+}
+
+// The link is the one an anonymous viewer would get, so private files don't leak signed links
+func (app *Application) webhookFileData(file Files) webhookFileData {
+	return webhookFileData{
+		FileName:         file.FileName,
+		OriginalFileName: file.OriginalFileName,
+		FileSize:         file.FileSize,
+		MimeType:         file.MimeType,
+		Public:           file.Public,
+		Url:              app.absoluteURL(app.fileURL(file, Accounts{})),
+		UploaderID:       file.UploaderID,
+		ExpiryDate:       file.ExpiryDate,
+	}
+}
+
+func (app *Application) absoluteURL(link string) string {
+	if strings.HasPrefix(link, "/") {
+		return app.config.PublicUrl + link
+	}
+
+	return link
+}
+
+// Queues the event for the webhooks of the account and every admin webhook, accountID 0 only reaches admin webhooks.
+// Failing to queue is logged but doesn't fail whatever caused the event.
+func (app *Application) emitWebhookEvent(ctx context.Context, event WebhookEvent, accountID uint, data any) {
+	webhooks, err := app.db.withContext(ctx).findWebhooksForAccount(accountID)
+	if err != nil {
+		log.Ctx(ctx).Err(err).Str("event", string(event)).Msg("Failed to find webhooks for event")
+		return
+	}
+
+	payload := webhookPayload{
+		ID:        uuid.NewString(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Ctx(ctx).Err(err).Str("event", string(event)).Msg("Failed to encode webhook payload")
+		return
+	}
+
+	var deliveries []WebhookDeliveries
+	for _, webhook := range webhooks {
+		if !webhook.subscribed(event) {
+			continue
+		}
+
+		deliveries = append(deliveries, WebhookDeliveries{
+			WebhookID:     webhook.ID,
+			EventID:       payload.ID,
+			Event:         event,
+			Payload:       string(body),
+			Status:        DeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return
+	}
+
+	if err = app.db.withContext(ctx).createWebhookDeliveries(deliveries); err != nil {
+		log.Ctx(ctx).Err(err).Str("event", string(event)).Msg("Failed to queue webhook deliveries")
+	}
+}
+
+// Sends every delivery that is due, run by the job scheduler
+func (app *Application) deliverWebhooks() {
+	deliveries, err := app.db.findDueWebhookDeliveries(time.Now(), webhookDeliveryBatch)
+	if err != nil {
+		log.Err(err).Msg("Failed to find due webhook deliveries")
+		return
+	}
+
+	if len(deliveries) == 0 {
+		return
+	}
+
+	client := app.webhookClient()
+	for _, delivery := range deliveries {
+		webhook, err := app.db.getWebhook(delivery.WebhookID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue // Deleted while the delivery was queued
+		} else if err != nil {
+			log.Err(err).Uint("delivery_id", delivery.ID).Msg("Failed to find webhook for delivery")
+			continue
+		}
+
+		status, err := sendWebhook(client, webhook, delivery)
+		app.recordWebhookAttempt(delivery, status, err)
+	}
+}
+
+func (app *Application) recordWebhookAttempt(delivery WebhookDeliveries, responseStatus int, sendErr error) {
+	delivery.Attempts++
+	delivery.ResponseStatus = responseStatus
+	delivery.LastError = ""
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = time.Now()
+	case delivery.Attempts >= app.config.Webhooks.MaxAttempts:
+		delivery.Status = DeliveryFailed
+		delivery.LastError = sendErr.Error()
+	default:
+		delivery.NextAttemptAt = time.Now().Add(webhookBackoff(delivery.Attempts))
+		delivery.LastError = sendErr.Error()
+	}
+
+	if sendErr != nil {
+		log.Warn().Err(sendErr).Uint("delivery_id", delivery.ID).Uint("attempts", delivery.Attempts).Msg("Webhook delivery failed")
+	}
+
+	if err := app.db.updateWebhookDelivery(delivery); err != nil {
+		log.Err(err).Uint("delivery_id", delivery.ID).Msg("Failed to update webhook delivery")
+	}
+}
+
+// Doubles the wait after every failed attempt, starting from 30 seconds
+func webhookBackoff(attempts uint) time.Duration {
+	wait := webhookRetryBase
+	for range attempts - 1 {
+		wait *= 2
+		if wait >= webhookRetryMax {
+			return webhookRetryMax
+		}
+	}
+
+	return wait
+}
+
+// Signature over the timestamp and body, receivers should reject old timestamps to stop replays
+func signWebhook(secret string, timestamp int64, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", timestamp, body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the response status, anything outside 2xx counts as a failed attempt
+func sendWebhook(client *http.Client, webhook Webhooks, delivery WebhookDeliveries) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, webhook.Url, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "hostling-webhook/"+Version)
+	request.Header.Set("X-Hostling-Event", string(delivery.Event))
+	request.Header.Set("X-Hostling-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set("X-Hostling-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set("X-Hostling-Signature", signWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024)) // Lets the connection be reused
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("%w: %s", ErrWebhookStatus, response.Status)
+	}
+
+	return response.StatusCode, nil
+}
+
+// Redirects aren't followed and addresses are checked after dns resolution, so a receiver can't point the request back into the internal network
+func (app *Application) webhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: app.config.Webhooks.Timeout}
+	if !app.config.Webhooks.AllowPrivateNetworks {
+		dialer.Control = publicAddressOnly
+	}
+
+	return &http.Client{
+		Timeout: app.config.Webhooks.Timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: app.config.Webhooks.Timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Minute,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, host)
+	}
+
+	return nil
+}
+
+func validateWebhookUrl(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return errors.New("url has to start with http:// or https://")
+	}
+
+	return nil
+}
+
+// The webhooks apis are shared between /api/account and /api/admin, admin decides which webhooks are managed
+func (app *Application) ownsWebhook(account Accounts, webhook Webhooks, admin bool) bool {
+	if admin {
+		return webhook.Admin
+	}
+
+	return !webhook.Admin && webhook.AccountID == account.ID
+}
+
+func (app *Application) accountWebhooksAPI(c *gin.Context) {
+	app.webhooksAPI(c, false)
+}
+
+func (app *Application) adminWebhooksAPI(c *gin.Context) {
+	app.webhooksAPI(c, true)
+}
+
+func (app *Application) accountCreateWebhookAPI(c *gin.Context) {
+	app.createWebhookAPI(c, false)
+}
+
+func (app *Application) adminCreateWebhookAPI(c *gin.Context) {
+	app.createWebhookAPI(c, true)
+}
+
+func (app *Application) accountDeleteWebhookAPI(c *gin.Context) {
+	app.deleteWebhookAPI(c, false)
+}
+
+func (app *Application) adminDeleteWebhookAPI(c *gin.Context) {
+	app.deleteWebhookAPI(c, true)
+}
+
+func (app *Application) accountWebhookDeliveriesAPI(c *gin.Context) {
+	app.webhookDeliveriesAPI(c, false)
+}
+
+func (app *Application) adminWebhookDeliveriesAPI(c *gin.Context) {
+	app.webhookDeliveriesAPI(c, true)
+}
+
+func (app *Application) accountRedeliverWebhookAPI(c *gin.Context) {
+	app.redeliverWebhookAPI(c, false)
+}
+
+func (app *Application) adminRedeliverWebhookAPI(c *gin.Context) {
+	app.redeliverWebhookAPI(c, true)
+}
+
+type WebhooksApiOutput struct {
+	Webhooks []Webhooks
+	Events   []WebhookEvent // Events that can be subscribed to
+}
+
+func (app *Application) webhooksAPI(c *gin.Context, admin bool) {
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var output WebhooksApiOutput
+	if output.Webhooks, err = app.db.withContext(c).getWebhooks(account.ID, admin); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get webhooks")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	for _, event := range WebhookEvents {
+		if admin || !slices.Contains(adminWebhookEvents, event) {
+			output.Events = append(output.Events, event)
+		}
+	}
+
+	c.JSON(http.StatusOK, output)
+}
+
+type createWebhookAPIInput struct {
+	Url    string   `form:"url"`
+	Events []string `form:"events"`
+}
+
+func (app *Application) createWebhookAPI(c *gin.Context, admin bool) {
+	var input createWebhookAPIInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	input.Url = strings.TrimSpace(input.Url)
+	if err := validateWebhookUrl(input.Url); err != nil {
+		c.String(http.StatusBadRequest, "Invalid url: %s", err)
+		return
+	}
+
+	if len(input.Events) == 0 {
+		c.String(http.StatusBadRequest, "Pick at least one event")
+		return
+	}
+
+	for _, event := range input.Events {
+		if !slices.Contains(WebhookEvents, WebhookEvent(event)) ||
+			!admin && slices.Contains(adminWebhookEvents, WebhookEvent(event)) {
+			c.String(http.StatusBadRequest, "Invalid event %q", event)
+			return
+		}
+	}
+
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	count, err := app.db.withContext(c).countWebhooks(account.ID, admin)
+	if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to count webhooks")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if count >= maxWebhooksPerAccount {
+		c.String(http.StatusBadRequest, "You can have at most %d webhooks", maxWebhooksPerAccount)
+		return
+	}
+
+	webhook := Webhooks{
+		AccountID: account.ID,
+		Admin:     admin,
+		Url:       input.Url,
+		Events:    strings.Join(input.Events, ","),
+		Secret:    generateToken("whsec_"),
+	}
+
+	if webhook, err = app.db.withContext(c).createWebhook(webhook); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to create webhook")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf("%s for %s", webhook.Url, webhook.Events)
+	if admin {
+		details += ", admin"
+	}
+	app.audit(c, account.ID, AuditCreateWebhook, webhookTarget(webhook.ID), details)
+
+	c.String(http.StatusOK, webhook.Secret)
+}
+
+type webhookIDInput struct {
+	ID uint `form:"id"`
+}
+
+// Looks up the webhook from the id in the form, aborts the request when the account doesn't manage it
+func (app *Application) webhookFromInput(c *gin.Context, admin bool, id uint) (account Accounts, webhook Webhooks, ok bool) {
+	account, err := app.accountFromContext(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	webhook, err = app.db.withContext(c).getWebhook(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && !app.ownsWebhook(account, webhook, admin) {
+		c.String(http.StatusNotFound, "Webhook not found")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find webhook")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	return account, webhook, true
+}
+
+func (app *Application) deleteWebhookAPI(c *gin.Context, admin bool) {
+	var input webhookIDInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	account, webhook, ok := app.webhookFromInput(c, admin, input.ID)
+	if !ok {
+		return
+	}
+
+	if err := app.db.withContext(c).deleteWebhook(webhook.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to delete webhook")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(c, account.ID, AuditDeleteWebhook, webhookTarget(webhook.ID), webhook.Url)
+
+	c.String(http.StatusOK, "Webhook deleted")
+}
+
+type WebhookDeliveriesApiInput struct {
+	ID   uint `form:"id"`
+	Skip uint `form:"skip"`
+}
+
+type WebhookDeliveriesApiOutput struct {
+	Deliveries []WebhookDeliveries
+	Count      int64
+}
+
+// Delivery log of a webhook, newest first and 25 at a time
+func (app *Application) webhookDeliveriesAPI(c *gin.Context, admin bool) {
+	var input WebhookDeliveriesApiInput
+	if err := c.MustBindWith(&input, binding.Form); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	_, webhook, ok := app.webhookFromInput(c, admin, input.ID)
+	if !ok {
+		return
+	}
+
+	var (
+		output WebhookDeliveriesApiOutput
+		err    error
+	)
+
+	if output.Deliveries, err = app.db.withContext(c).getWebhookDeliveries(webhook.ID, input.Skip, 25); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to get webhook deliveries")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if output.Count, err = app.db.withContext(c).countWebhookDeliveries(webhook.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to count webhook deliveries")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, output)
+}
+
+type redeliverWebhookAPIInput struct {
+	DeliveryID uint `form:"delivery_id"`
+}
+
+// Queues a finished delivery again with a fresh set of attempts
+func (app *Application) redeliverWebhookAPI(c *gin.Context, admin bool) {
+	var input redeliverWebhookAPIInput
+	if err := c.MustBindWith(&input, binding.FormPost); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	delivery, err := app.db.withContext(c).getWebhookDelivery(input.DeliveryID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Delivery not found")
+		return
+	} else if err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to find webhook delivery")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if _, _, ok := app.webhookFromInput(c, admin, delivery.WebhookID); !ok {
+		return
+	}
+
+	if delivery.Status == DeliveryPending {
+		c.String(http.StatusConflict, "Delivery is already queued")
+		return
+	}
+
+	if err = app.db.withContext(c).requeueWebhookDelivery(delivery.ID); err != nil {
+		log.Ctx(c).Err(err).Msg("Failed to queue webhook delivery again")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.String(http.StatusOK, "Delivery queued again")
+}
+
+func (app *Application) adminWebhooksPage(c *gin.Context) {
+	_, account, loggedIn, err := app.validateAuthCookie(c)
+	if errors.Is(err, ErrInvalidAuthCookie) {
+		app.clearAuthCookie(c)
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if !loggedIn || !account.AccountType.Can(PermManageWebhooks) {
+		c.Redirect(http.StatusTemporaryRedirect, "/login")
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_webhooks.gohtml", gin.H{
+		"CurrentPage":  "admin",
+		"Branding":     app.config.Branding,
+		"Tagline":      app.config.Tagline,
+		"LoggedIn":     true,
+		"AccountID":    account.ID,
+		"CanViewAdmin": true,
+		"CsrfToken":    app.csrfToken(c),
+		"Role":         account.AccountType,
+	})
+}
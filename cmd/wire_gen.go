@@ -13,11 +13,13 @@ func InitializeApplication() *Application {
 	database := prepareDB(config)
 	s3 := prepareStorage(config)
 	limiter := setupRatelimiting(config)
+	fileScanner := prepareScanner(config)
 	cmdUninitializedApplication := &uninitializedApplication{
 		config:      config,
 		db:          database,
 		s3client:    s3,
 		RateLimiter: limiter,
+		scanner:     fileScanner,
 	}
 	application := setupRouter(cmdUninitializedApplication, config)
 	return application
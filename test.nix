@@ -42,5 +42,7 @@ testers.nixosTest {
       machine.wait_for_unit("hostling.service")
       machine.wait_for_open_port(${port})
       machine.succeed("curl -f http://localhost:${port}/")
+      machine.succeed("curl -f http://localhost:${port}/healthz")
+      machine.succeed("curl -f http://localhost:${port}/readyz")
     '';
 }